@@ -3,12 +3,22 @@ package agecache
 
 import (
 	"container/list"
+	"context"
+	"encoding/json"
 	"errors"
+	"math"
 	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrFrozen is returned, or panicked with, when modifying a frozen cache.
+var ErrFrozen = errors.New("Cannot modify a frozen cache")
+
 // Stats hold cache statistics.
 //
 // The struct supports stats package tags, example:
@@ -17,29 +27,71 @@ import (
 //	s := cache.Stats().Delta(prev)
 //	stats.WithPrefix("mycache").Observe(s)
 type Stats struct {
-	Capacity  int64 `metric:"capacity" type:"gauge"`    // Gauge, maximum capacity for the cache
-	Count     int64 `metric:"count" type:"gauge"`       // Gauge, number of items in the cache
-	Sets      int64 `metric:"sets" type:"counter"`      // Counter, number of sets
-	Gets      int64 `metric:"gets" type:"counter"`      // Counter, number of gets
-	Hits      int64 `metric:"hits" type:"counter"`      // Counter, number of cache hits from Get operations
-	Misses    int64 `metric:"misses" type:"counter"`    // Counter, number of cache misses from Get operations
-	Evictions int64 `metric:"evictions" type:"counter"` // Counter, number of evictions
+	Capacity      int64  `metric:"capacity" type:"gauge" json:"capacity"`                 // Gauge, maximum capacity for the cache
+	Count         int64  `metric:"count" type:"gauge" json:"count"`                       // Gauge, number of items in the cache
+	Sets          int64  `metric:"sets" type:"counter" json:"sets"`                       // Counter, number of sets
+	Inserts       int64  `metric:"inserts" type:"counter" json:"inserts"`                 // Counter, number of sets of a key not in the cache
+	Overwrites    int64  `metric:"overwrites" type:"counter" json:"overwrites"`           // Counter, number of sets of a key already in the cache
+	Gets          int64  `metric:"gets" type:"counter" json:"gets"`                       // Counter, number of gets
+	Hits          int64  `metric:"hits" type:"counter" json:"hits"`                       // Counter, number of cache hits from Get operations
+	Misses        int64  `metric:"misses" type:"counter" json:"misses"`                   // Counter, number of cache misses from Get operations
+	Evictions     int64  `metric:"evictions" type:"counter" json:"evictions"`             // Counter, number of evictions
+	Rejections    int64  `metric:"rejections" type:"counter" json:"rejections"`           // Counter, number of new items rejected at capacity by RejectNewOverflow
+	SkippedBySize int64  `metric:"skipped_by_size" type:"counter" json:"skipped_by_size"` // Counter, number of values not cached for falling outside MinCacheBytes and MaxCacheBytes
+	Throttled     int64  `metric:"throttled" type:"counter" json:"throttled"`             // Counter, number of new items rejected for exceeding MaxEvictionsPerSecond
+	Cost          int64  `metric:"cost" type:"gauge" json:"cost"`                         // Gauge, total cost of the items in the cache, if a CostFunc is configured
+	MaxCost       int64  `metric:"max_cost" type:"gauge" json:"max_cost"`                 // Gauge, maximum total cost for the cache, if bounded
+	Name          string `tag:"name" json:"name"`                                         // Tag, name of the cache, if configured
+
+	AvgEvictionAge time.Duration `metric:"avg_eviction_age" type:"gauge" json:"avg_eviction_age"` // Gauge, average age of the items evicted due to the LRU policy
+	MinEvictionAge time.Duration `metric:"min_eviction_age" type:"gauge" json:"min_eviction_age"` // Gauge, youngest age of an item evicted due to the LRU policy
+	MaxEvictionAge time.Duration `metric:"max_eviction_age" type:"gauge" json:"max_eviction_age"` // Gauge, oldest age of an item evicted due to the LRU policy
 }
 
 // Delta returns a Stats object such that all counters are calculated as the
 // difference since the previous.
 func (stats Stats) Delta(previous Stats) Stats {
 	return Stats{
-		Capacity:  stats.Capacity,
-		Count:     stats.Count,
-		Sets:      stats.Sets - previous.Sets,
-		Gets:      stats.Gets - previous.Gets,
-		Hits:      stats.Hits - previous.Hits,
-		Misses:    stats.Misses - previous.Misses,
-		Evictions: stats.Evictions - previous.Evictions,
+		Capacity:      stats.Capacity,
+		Count:         stats.Count,
+		Sets:          stats.Sets - previous.Sets,
+		Inserts:       stats.Inserts - previous.Inserts,
+		Overwrites:    stats.Overwrites - previous.Overwrites,
+		Gets:          stats.Gets - previous.Gets,
+		Hits:          stats.Hits - previous.Hits,
+		Misses:        stats.Misses - previous.Misses,
+		Evictions:     stats.Evictions - previous.Evictions,
+		Rejections:    stats.Rejections - previous.Rejections,
+		SkippedBySize: stats.SkippedBySize - previous.SkippedBySize,
+		Throttled:     stats.Throttled - previous.Throttled,
+		Cost:          stats.Cost,
+		MaxCost:       stats.MaxCost,
+		Name:          stats.Name,
+
+		AvgEvictionAge: stats.AvgEvictionAge,
+		MinEvictionAge: stats.MinEvictionAge,
+		MaxEvictionAge: stats.MaxEvictionAge,
 	}
 }
 
+// StatsExtended holds a Stats snapshot along with metrics derived from it at
+// the same instant, e.g. for a debug endpoint.
+type StatsExtended struct {
+	Stats
+	HitRatio        float64       `json:"hit_ratio"`         // Hits divided by Hits + Misses
+	FillRatio       float64       `json:"fill_ratio"`        // Count divided by Capacity
+	EvictionsPerSet float64       `json:"evictions_per_set"` // Evictions divided by Sets
+	OldestAge       time.Duration `json:"oldest_age"`        // Age of the oldest item in the cache, including any jitter
+}
+
+// LockStats hold write lock instrumentation, collected when
+// config.TrackLockHold is set.
+type LockStats struct {
+	Acquisitions int64         // Number of times the write lock was held
+	AvgLockHold  time.Duration // Average duration the write lock was held
+	MaxLockHold  time.Duration // Longest duration the write lock was held
+}
+
 // RandGenerator represents a random number generator.
 type RandGenerator interface {
 	Int63n(n int64) int64
@@ -59,9 +111,88 @@ const (
 	ActiveExpiration
 )
 
+// EvictionPolicy enumerates how items are chosen for eviction when the cache
+// is over capacity or MaxCost.
+type EvictionPolicy int
+
+const (
+	// LRUEviction evicts the least recently used item, or the least recently
+	// accessed of the sampled items when SampleSize is set.
+	LRUEviction EvictionPolicy = iota
+
+	// CostAwareEviction evicts the sampled item with the greatest cost
+	// multiplied by the time since it was last accessed, preferentially
+	// reclaiming large, stale items. Requires SampleSize and CostFunc.
+	CostAwareEviction
+)
+
+// OverflowPolicy enumerates how a cache at capacity handles setting a new key.
+type OverflowPolicy int
+
+const (
+	// EvictOldestOverflow evicts the oldest item to make room for the new one.
+	EvictOldestOverflow OverflowPolicy = iota
+
+	// RejectNewOverflow keeps the existing items, and does not store the new
+	// one. Rejections are counted in Stats.
+	RejectNewOverflow
+)
+
+// RemoveReason enumerates the reasons an item left the cache.
+type RemoveReason int
+
+const (
+	// ReasonEvicted indicates the item was evicted due to the LRU policy.
+	ReasonEvicted RemoveReason = iota
+
+	// ReasonExpired indicates the item expired.
+	ReasonExpired
+
+	// ReasonRemoved indicates the item was explicitly removed, e.g. by
+	// Remove, Clear or RefreshCache.
+	ReasonRemoved
+
+	// ReasonResized indicates the item was evicted to fit a reduced capacity,
+	// e.g. by Resize or Reconfigure.
+	ReasonResized
+)
+
 // Config configures the cache.
 type Config struct {
-	// Maximum number of items in the cache
+	// Optional name of the cache, reported by Name and as a tag in Stats to
+	// tell caches apart in metrics and logs.
+	Name string
+	// Optional function canonicalizing keys, e.g. lowercasing strings, applied
+	// to the key passed to every keyed operation before it is looked up or
+	// stored. Keys are stored, and reported by Keys, in canonical form.
+	KeyFunc func(key interface{}) interface{}
+	// Optional function reporting whether a key:value pair may be cached. When
+	// it returns false, Set and the other methods storing items skip the pair
+	// and remove any item already stored at the key, so that it is never
+	// served stale.
+	ShouldCache func(key, value interface{}) bool
+	// Optional function returning the size of a value in bytes, e.g. the length
+	// of an encoded response, against which MinCacheBytes and MaxCacheBytes
+	// are checked
+	SizeFunc func(key, value interface{}) int64
+	// Optional minimum size of a value to cache, e.g. to skip values that are
+	// cheap to recompute. Smaller values are skipped as by ShouldCache, and
+	// counted by Stats.SkippedBySize. Requires SizeFunc. If zero, values are
+	// not bounded below.
+	MinCacheBytes int64
+	// Optional maximum size of a value to cache, e.g. to skip values that
+	// would evict too many others. Larger values are skipped as by
+	// ShouldCache, and counted by Stats.SkippedBySize. Requires SizeFunc. If
+	// zero, values are not bounded above.
+	MaxCacheBytes int64
+	// Optional flag making IncrementOrSet keep the timestamp of the item it
+	// increments, rather than resetting it as Set would
+	IncrementPreservesTTL bool
+	// Optional Recorder logging the items set, read, removed, renamed and
+	// updated in place in the cache, for Replay to reproduce them
+	Recorder *Recorder
+	// Maximum number of items in the cache. If zero, the cache is disabled:
+	// nothing is stored and every Get misses.
 	Capacity int
 	// Optional max duration before an item expires. Must be greater than or
 	// equal to MinAge. If zero, expiration is disabled.
@@ -70,348 +201,1478 @@ type Config struct {
 	// to MaxAge. When less than MaxAge, uniformly distributed random jitter is
 	// added to the expiration time. If equal or zero, jitter is disabled.
 	MinAge time.Duration
+	// Optional window before an item expires within which a Get resets its
+	// timestamp, extending its life as a Set would. The new timestamp is
+	// subject to jitter, so an extended item lives between MinAge and MaxAge
+	// from the read. If zero, reads never extend items.
+	ExtendOnReadWithin time.Duration
+	// Optional maximum lifetime of an item from when it was first set, after
+	// which it expires however recently it was set again or extended on read.
+	// Items moved to or from an overflow cache, or loaded by LoadFrom, keep
+	// their creation time. If zero, lifetime is only bounded by MaxAge.
+	MaxLifetime time.Duration
+	// Optional duration after which an item is stale, though still served until
+	// it expires after MaxAge, as reported by GetWithFreshness. Must not be
+	// greater than MaxAge, if set. If zero, items are fresh until they expire.
+	SoftMaxAge time.Duration
+	// Optional function returning the time at which an item expires, e.g. from
+	// an expiry embedded in the value, evaluated whenever the item is set. An
+	// item expires at the earlier of this deadline and its MaxAge. A zero time
+	// sets no deadline. With active expiration and no MaxAge or MaxLifetime,
+	// ExpirationInterval must be set for the sweep to run.
+	DeadlineFunc func(key, value interface{}) time.Time
 	// Type of key expiration: Passive or Active
 	ExpirationType ExpirationType
 	// For active expiration, how often to iterate over the keyspace. Defaults
-	// to the MaxAge
+	// to the MaxAge, or MaxLifetime if MaxAge is zero
 	ExpirationInterval time.Duration
 	// Optional callback invoked when an item is evicted due to the LRU policy
 	OnEviction func(key, value interface{})
 	// Optional callback invoked when an item expired
 	OnExpiration func(key, value interface{})
+	// Optional callback invoked whenever an item leaves the cache, with the
+	// reason it was removed. Unlike OnEviction, it distinguishes evictions due
+	// to capacity pressure from those forced by a resize.
+	OnRemoval func(key, value interface{}, reason RemoveReason)
+	// Optional channel receiving an Event whenever an item leaves the cache.
+	// Events are sent without blocking under the lock, and dropped if the
+	// channel is not ready, so it should be buffered and drained promptly.
+	Events chan<- Event
+	// Optional callback invoked once per active expiration sweep with all items
+	// expired during that sweep, outside of the cache lock. When set, it is
+	// used instead of OnExpiration for items expired by the sweep.
+	OnExpirationBatch func(entries []Entry)
+	// Optional flag making Has delete an expired item it encounters, invoking
+	// OnExpiration, as Get would
+	HasReapsExpired bool
+	// Optional function returning the cost of an item, e.g. its size in bytes.
+	// The total cost of all items is reported by Stats, and bounded by MaxCost.
+	CostFunc func(key, value interface{}) int64
+	// Optional maximum total cost of the items in the cache. When exceeded,
+	// the oldest items are evicted until it is no longer. Requires CostFunc.
+	// If zero, the total cost is unbounded.
+	MaxCost int64
+	// Optional number of items to sample when evicting. When set, accesses do
+	// not reorder items, and eviction removes the least recently accessed of
+	// SampleSize randomly sampled items rather than the exact least recently
+	// used item. Eviction order methods such as OrderedKeys and OldestN then
+	// reflect insertion order. Get then serves hits under the read lock, unless
+	// ExtendOnReadWithin or StatsWindow is set. If zero, eviction is strict LRU.
+	SampleSize int
+	// Optional policy choosing which item to evict. Defaults to LRUEviction.
+	EvictionPolicy EvictionPolicy
+	// Optional policy for setting a new key when the cache is at capacity.
+	// Defaults to EvictOldestOverflow.
+	OverflowPolicy OverflowPolicy
+	// Optional maximum number of items evicted per second, e.g. to smooth the
+	// writes of an OnEviction callback to a downstream store. Once reached,
+	// setting a new key that would evict an item, due to the capacity,
+	// MaxPerGroup or MaxCost, is rejected until the next second, and counted
+	// by Stats.Throttled. Evictions that cannot be refused, i.e. those due to
+	// an overwrite raising the total cost, a resize or EnableEviction, are not
+	// throttled, but count towards the limit. If zero, evictions are not rate
+	// limited.
+	MaxEvictionsPerSecond int
+	// Optional function assigning each key to a logical group, e.g. a tenant.
+	// Required for MaxPerGroup.
+	GroupFunc func(key interface{}) string
+	// Optional maximum number of items per group. When a group is at its
+	// quota, inserting a new key in that group evicts the oldest item within
+	// the group rather than the oldest overall. If zero, groups are unbounded.
+	MaxPerGroup int
+	// Optional callback invoked when the number of items reaches capacity,
+	// having previously been below it
+	OnFull func()
+	// Optional callback invoked when the number of items drops below capacity,
+	// having previously reached it
+	OnNotFull func()
+	// Optional callback invoked, outside the lock, when Set or one of its
+	// variants, UpdateFunc or an AutoRefreshInterval reload overwrites an
+	// existing item with a different value. Values are compared with Equal if
+	// provided, otherwise with ==, treating values of incomparable types as
+	// always different.
+	OnChange func(key, old, new interface{})
+	// Optional function reporting whether two values are equal, used by
+	// OnChange
+	Equal func(old, new interface{}) bool
+	// Optional cache that items evicted due to the LRU policy spill over into,
+	// keeping their remaining time to live, instead of being dropped. OnEviction
+	// is not invoked for spilled items. A Get that misses checks the overflow
+	// cache and promotes the item back. The cache's lock is held while
+	// locking the overflow cache, so it must not be the cache itself or
+	// overflow back into it through its own Overflow. Reconfigure rejects such
+	// cycles, though not one closed by concurrent calls.
+	Overflow *Cache
 	// Optional refresh interval after which all items in the cache expires.
 	// If zero, refreshing cache is disabled.
 	RefreshInterval time.Duration
 	// Optional on refresh callback invoked when the cache is refreshed
 	// Both RefreshInterval and OnRefresh must be provided to enable background cache refresh
 	OnRefresh func() map[interface{}]interface{}
+	// Optional interval after which every item is reloaded in the background
+	// with Reloader, replacing its value in place without changing how recently
+	// it was used. Items that fail to reload keep their value until they
+	// expire. Both AutoRefreshInterval and Reloader must be provided.
+	AutoRefreshInterval time.Duration
+	// Optional function returning the current value for an item, used by
+	// AutoRefreshInterval. It is invoked outside the lock.
+	Reloader func(key, value interface{}) (interface{}, error)
+	// Optional flag disabling the background goroutines for active expiration,
+	// RefreshInterval and AutoRefreshInterval, e.g. for deterministic tests.
+	// Each runs only when Tick is called instead.
+	ManualSweep bool
+	// Optional hook invoked by the WithContext variants of cache operations
+	// with the context, operation name and whether the lookup was a hit. Can
+	// be used to bridge cache operations to a tracing system.
+	Tracer func(ctx context.Context, op string, hit bool)
+	// Optional flag to record how long the write lock is held per operation,
+	// reported by LockStats. Adds a clock read per lock acquisition.
+	TrackLockHold bool
+	// Optional longest window WindowedStats can report on, rounded up to the
+	// second. Adds a clock read per counted operation. If zero, windowed stats
+	// are disabled.
+	StatsWindow time.Duration
+	// Optional flag making validation failures panic rather than return an
+	// error, consistently across NewWithError, Reconfigure, SetMaxAge,
+	// SetMinAge and Resize. New always panics given an invalid config.
+	StrictMode bool
+	// Optional flag to skip all statistics bookkeeping. When set, Stats only
+	// reports Capacity and Count.
+	DisableStats bool
+}
+
+// Event describes an item leaving the cache, as sent to config.Events.
+type Event struct {
+	Cache      string        // Name of the cache, if configured
+	Key        interface{}   // Key of the item
+	Value      interface{}   // Value of the item
+	Reason     RemoveReason  // Reason the item was removed
+	Age        time.Duration // Age of the item, from the timestamp used for expiration
+	LastAccess time.Time     // When the item was last set or read
+}
+
+// Entry is a key:value pair stored in the cache.
+type Entry struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// EntryInfo describes an item in the cache, for diagnostics.
+type EntryInfo struct {
+	Key        interface{}
+	Value      interface{}
+	Timestamp  time.Time // Timestamp used for expiration, including any jitter
+	LastAccess time.Time // When the item was last set or read
+	Cost       int64     // Cost of the item, if a CostFunc is configured
 }
 
 // Entry pointed to by each list.Element
 type cacheEntry struct {
-	key       interface{}
-	value     interface{}
-	timestamp time.Time
+	key        interface{}
+	value      interface{}
+	timestamp  time.Time
+	createdAt  time.Time
+	deadline   time.Time
+	lastAccess atomic.Int64 // Unix nanoseconds, updated by sampled reads under the read lock
+	version    uint64
+	noExtend   bool
+	dependsOn  []interface{}
+	group      string
+	cost       int64
 }
 
-// Cache implements a thread-safe fixed-capacity LRU cache.
-type Cache struct {
-	// Fields defined by configuration
-	capacity           int
-	minAge             time.Duration
-	maxAge             time.Duration
-	expirationType     ExpirationType
-	expirationInterval time.Duration
-	onEviction         func(key, value interface{})
-	onExpiration       func(key, value interface{})
+// Pool of cacheEntry allocations, shared by all caches. Entries are returned
+// to the pool once removed and no longer referenced, such as after eviction.
+// List elements cannot be reused by container/list, so only entries are.
+var entryPool = sync.Pool{
+	New: func() interface{} { return new(cacheEntry) },
+}
 
-	// Cache statistics
-	sets      int64
-	gets      int64
-	hits      int64
-	misses    int64
-	evictions int64
+// newEntry returns a cacheEntry from the pool.
+func newEntry(key, value interface{}, timestamp time.Time) *cacheEntry {
+	entry := entryPool.Get().(*cacheEntry)
+	entry.key = key
+	entry.value = value
+	entry.timestamp = timestamp
+	entry.createdAt = time.Now()
+	entry.lastAccess.Store(entry.createdAt.UnixNano())
+	return entry
+}
 
-	items        map[interface{}]*list.Element
-	evictionList *list.List
-	mutex        sync.RWMutex
-	rand         RandGenerator
+// accessedAt returns when the entry was last set or read.
+func (entry *cacheEntry) accessedAt() time.Time {
+	return time.Unix(0, entry.lastAccess.Load())
 }
 
-// New constructs an LRU Cache with the given Config object. config.Capacity
-// must be a positive int, and config.MaxAge a zero or positive duration. A
-// duration of zero disables item expiration. Panics given an invalid
-// config.Capacity or config.MaxAge.
-func New(config Config) *Cache {
-	if config.Capacity <= 0 {
-		panic("Must supply a positive config.Capacity")
-	}
+// freeEntry returns a removed entry to the pool. The caller must not retain
+// any reference to the entry.
+func freeEntry(entry *cacheEntry) {
+	*entry = cacheEntry{}
+	entryPool.Put(entry)
+}
 
-	if config.MaxAge < 0 {
-		panic("Must supply a zero or positive config.MaxAge")
-	}
+// Callback registered for a single key with OnKeyRemoved
+type keyCallback struct {
+	fn         func(value interface{}, reason RemoveReason)
+	persistent bool
+}
 
-	if config.MinAge < 0 {
-		panic("Must supply a zero or positive config.MinAge")
-	}
+// Cache implements a thread-safe fixed-capacity LRU cache.
+type Cache struct {
+	// Fields defined by configuration
+	name                  string
+	keyFunc               func(key interface{}) interface{}
+	shouldCache           func(key, value interface{}) bool
+	sizeFunc              func(key, value interface{}) int64
+	minCacheBytes         int64
+	maxCacheBytes         int64
+	recorder              *Recorder
+	incrementPreservesTTL bool
+	capacity              int
+	minAge                time.Duration
+	maxAge                time.Duration
+	extendOnReadWithin    time.Duration
+	maxLifetime           time.Duration
+	softMaxAge            time.Duration
+	deadlineFunc          func(key, value interface{}) time.Time
+	expirationType        ExpirationType
+	expirationInterval    time.Duration
+	onEviction            func(key, value interface{})
+	onExpiration          func(key, value interface{})
+	onExpirationBatch     func(entries []Entry)
+	onRemoval             func(key, value interface{}, reason RemoveReason)
+	events                chan<- Event
+	costFunc              func(key, value interface{}) int64
+	maxCost               int64
+	sampleSize            int
+	evictionPolicy        EvictionPolicy
+	overflowPolicy        OverflowPolicy
+	maxEvictionsPerSecond int
+	groupFunc             func(key interface{}) string
+	maxPerGroup           int
+	onFull                func()
+	onNotFull             func()
+	onChange              func(key, old, new interface{})
+	equal                 func(old, new interface{}) bool
+	hasReapsExpired       bool
+	overflow              *Cache
+	tracer                func(ctx context.Context, op string, hit bool)
+	strict                bool
 
-	if config.MinAge > 0 && config.MinAge > config.MaxAge {
-		panic("config.MinAge must be less than or equal to config.MaxAge")
-	}
+	// Cache statistics
+	statsDisabled bool
+	sets          int64
+	inserts       int64
+	overwrites    int64
+	gets          int64
+	hits          int64
+	misses        int64
+	evictions     int64
+	rejections    int64
+	skippedBySize int64
+	throttled     int64
+
+	// Evictions in the current second, for MaxEvictionsPerSecond
+	evictionSecond      int64
+	evictionsThisSecond int
+
+	// Ages of the items evicted due to the LRU policy
+	lruEvictions     int64
+	evictionAgeTotal time.Duration
+	evictionAgeMin   time.Duration
+	evictionAgeMax   time.Duration
+
+	// Snapshots of the stats for WindowedStats
+	statsWindow time.Duration
+	window      *statsWindow
+
+	// Lock instrumentation
+	trackLockHold bool
+	lockedAt      time.Time
+	lockHolds     int64
+	lockHoldTotal time.Duration
+	lockHoldMax   time.Duration
+
+	items            map[interface{}]*list.Element
+	evictionList     *list.List
+	keyCallbacks     map[interface{}][]keyCallback
+	waiters          map[interface{}][]chan interface{}
+	groups           map[string]int
+	dependents       map[interface{}]map[interface{}]struct{}
+	cost             int64
+	full             bool
+	clears           uint64 // Number of times the cache was cleared or refreshed
+	version          uint64 // Version assigned to the last item set
+	evictionDisabled int    // Number of DisableEviction calls not yet matched by EnableEviction
+	mutex            sync.RWMutex
+	rand             RandGenerator
+	stop             chan struct{}
+	ticks            []func()
+	frozen           atomic.Bool
+}
 
-	if config.RefreshInterval < 0 {
-		panic("Must supply a zero or positive config.RefreshInterval")
+// New constructs an LRU Cache with the given options, applied in order. A
+// Config is itself an Option, so New(Config{...}) constructs a cache from a
+// Config object. config.Capacity must be a zero or positive int, and
+// config.MaxAge a zero or positive duration. A capacity of zero constructs a
+// disabled cache that stores nothing. A duration of zero disables item expiration.
+// Panics given an invalid config.Capacity or config.MaxAge.
+func New(opts ...Option) *Cache {
+	cache, err := NewWithError(opts...)
+	if err != nil {
+		panic(err.Error())
 	}
+	return cache
+}
 
-	minAge := config.MinAge
-	if minAge == 0 {
-		minAge = config.MaxAge
+// NewWithError constructs an LRU Cache like New, returning an error instead
+// of panicking given an invalid config, unless config.StrictMode is set.
+func NewWithError(opts ...Option) (*Cache, error) {
+	var config Config
+	for _, opt := range opts {
+		opt.apply(&config)
 	}
 
-	interval := config.ExpirationInterval
-	if interval <= 0 {
-		interval = config.MaxAge
+	if err := config.Validate(); err != nil {
+		if config.StrictMode {
+			panic(err.Error())
+		}
+		return nil, err
 	}
 
 	seed := rand.NewSource(time.Now().UnixNano())
 
 	cache := &Cache{
-		capacity:           config.Capacity,
-		maxAge:             config.MaxAge,
-		minAge:             minAge,
-		expirationType:     config.ExpirationType,
-		expirationInterval: interval,
-		onEviction:         config.OnEviction,
-		onExpiration:       config.OnExpiration,
-		items:              make(map[interface{}]*list.Element),
-		evictionList:       list.New(),
-		rand:               rand.New(seed),
-	}
-
-	if config.ExpirationType == ActiveExpiration && interval > 0 {
-		go func() {
-			for range time.Tick(interval) {
-				cache.deleteExpired()
-			}
-		}()
+		items:        make(map[interface{}]*list.Element),
+		evictionList: list.New(),
+		keyCallbacks: make(map[interface{}][]keyCallback),
+		waiters:      make(map[interface{}][]chan interface{}),
+		rand:         rand.New(seed),
 	}
+	cache.configure(config)
 
 	if config.RefreshInterval > 0 && config.OnRefresh != nil {
 		cache.RefreshCache(config.OnRefresh())
-		go func() {
-			t := time.NewTicker(config.RefreshInterval)
-			defer t.Stop()
-			for {
-				<-t.C
-				items := config.OnRefresh()
-				// Only refresh the cache if the items provided is not nil
-				if items != nil {
-					cache.RefreshCache(items)
-				}
-			}
-		}()
 	}
+	cache.startBackground(config)
 
-	return cache
+	return cache, nil
+}
+
+// Reconfigure atomically applies a new Config to the cache. The config is
+// validated first, and an error is returned without changing anything if it
+// is invalid. Otherwise all settings are applied under the lock, entries are
+// evicted if the new capacity is smaller than the current size, and the
+// background expiration and refresh goroutines are restarted. Entries are
+// otherwise kept, and expire according to the new settings. It also errors if
+// config.Overflow would overflow back into the cache.
+func (cache *Cache) Reconfigure(config Config) error {
+	// The chain is walked before locking the cache, which it may lead to
+	cycle := config.Overflow != nil && cache.reachableFrom(config.Overflow)
+
+	cache.lock()
+	defer cache.unlock()
+
+	if err := config.Validate(); err != nil {
+		return cache.invalid(err)
+	} else if cycle {
+		return cache.invalid(errors.New("config.Overflow must not overflow back into the cache"))
+	}
+
+	if cache.frozen.Load() {
+		return ErrFrozen
+	}
+
+	cache.stopBackground()
+	cache.configure(config)
+	cache.evictToFit(ReasonResized)
+	cache.evictOverCost()
+	cache.checkFull()
+	cache.startBackground(config)
+
+	return nil
 }
 
 // Set updates a key:value pair in the cache. Returns true if an eviction
 // occurrred, and subsequently invokes the OnEviction callback.
 func (cache *Cache) Set(key, value interface{}) bool {
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+	var evicted bool
+	cache.setValue(key, value, func(key, value interface{}) {
+		_, evicted = cache.set(key, value, cache.getTimestamp())
+	})
+	return evicted
+}
 
-	cache.sets++
-	timestamp := cache.getTimestamp()
+// SetNoExtend updates a key:value pair in the cache as Set would, marking the
+// item so that reads never extend it under config.ExtendOnReadWithin, and it
+// expires by its age since being set. Setting the key again clears the mark.
+func (cache *Cache) SetNoExtend(key, value interface{}) bool {
+	var evicted bool
+	cache.setValue(key, value, func(key, value interface{}) {
+		_, evicted = cache.set(key, value, cache.getTimestamp())
+		if element, ok := cache.items[key]; ok {
+			element.Value.(*cacheEntry).noExtend = true
+		}
+	})
+	return evicted
+}
 
-	if element, ok := cache.items[key]; ok {
-		cache.evictionList.MoveToFront(element)
-		entry := element.Value.(*cacheEntry)
-		entry.value = value
-		entry.timestamp = timestamp
-		return false
-	}
+// SetWithDeps updates a key:value pair in the cache as Set would, recording
+// that the value is derived from the items at the dependsOn keys. Whenever one
+// of those keys is removed from the cache, whether removed, evicted or
+// expired, the item is removed too, with ReasonRemoved, and so are the items
+// depending on it in turn. Items drained by DrainFunc, or promoted from an
+// overflow cache, are moved rather than invalidated, and leave their
+// dependents in place.
+// Overwriting a key it depends on does not remove it. Setting the key again
+// replaces its dependencies, and Set clears them. Cyclic dependencies are
+// allowed, and removing any key in a cycle removes the others.
+func (cache *Cache) SetWithDeps(key, value interface{}, dependsOn ...interface{}) bool {
+	var evicted bool
+	cache.setValue(key, value, func(key, value interface{}) {
+		_, evicted = cache.set(key, value, cache.getTimestamp())
+		if element, ok := cache.items[key]; ok {
+			bases := make([]interface{}, 0, len(dependsOn))
+			for _, base := range dependsOn {
+				if base = cache.canonical(base); base != key {
+					bases = append(bases, base)
+				}
+			}
+			cache.depend(element.Value.(*cacheEntry), bases)
+		}
+	})
+	return evicted
+}
 
-	entry := &cacheEntry{key, value, timestamp}
-	element := cache.evictionList.PushFront(entry)
-	cache.items[key] = element
+// TrySet updates a key:value pair in the cache as Set would, returning whether
+// or not the value was stored. It is not stored if ShouldCache rejects it, if
+// its size is outside MinCacheBytes and MaxCacheBytes, or if the key is new and
+// the cache is at capacity with RejectNewOverflow.
+func (cache *Cache) TrySet(key, value interface{}) bool {
+	var stored bool
+	cache.setValue(key, value, func(key, value interface{}) {
+		cache.set(key, value, cache.getTimestamp())
+		_, stored = cache.items[key]
+	})
+	return stored
+}
 
-	evict := cache.evictionList.Len() > cache.capacity
-	if evict {
-		cache.evictOldest()
-	}
-	return evict
+// ForceSet updates a key:value pair in the cache as Set would, storing a new
+// key even when the cache is at capacity with RejectNewOverflow by evicting the
+// oldest item, invoking the eviction callbacks. Items rejected by ShouldCache
+// are still not stored. Returns true if an eviction occurred.
+func (cache *Cache) ForceSet(key, value interface{}) bool {
+	var evicted bool
+	cache.setValue(key, value, func(key, value interface{}) {
+		_, evicted = cache.insert(key, value, cache.getTimestamp(), time.Time{}, true)
+	})
+	return evicted
+}
+
+// SetAndReport updates a key:value pair in the cache like Set, returning the
+// key and value of the item evicted to make room, if any.
+func (cache *Cache) SetAndReport(key, value interface{}) (evictedKey, evictedValue interface{}, evicted bool) {
+	cache.setValue(key, value, func(key, value interface{}) {
+		var victim Entry
+		if victim, evicted = cache.set(key, value, cache.getTimestamp()); evicted {
+			evictedKey, evictedValue = victim.Key, victim.Value
+		}
+	})
+	return evictedKey, evictedValue, evicted
+}
+
+// IncrementOrSet atomically adds delta to the integer value stored at `key`,
+// returning the result. The result is stored with the type of the value, e.g.
+// as an int if an int was stored, wrapping around on overflow of smaller
+// types. If the key is missing, expired or not holding an integer, initial is
+// set instead as an int64 and returned. The result is stored as Set would store
+// it, so it is subject to ShouldCache and the size limits. If rejected, it is
+// returned without being stored and the previous value is removed, as for Set.
+// The item's timestamp is reset as for Set, unless config.IncrementPreservesTTL
+// is set.
+func (cache *Cache) IncrementOrSet(key interface{}, delta, initial int64) int64 {
+	result := initial
+	var timestamp time.Time
+	cache.setFunc(key, func(key interface{}) (interface{}, bool) {
+		timestamp = cache.getTimestamp()
+		if element, ok := cache.items[key]; ok {
+			entry := element.Value.(*cacheEntry)
+			if !cache.expired(entry) {
+				if value, n, ok := increment(entry.value, delta); ok {
+					result = n
+					if cache.incrementPreservesTTL {
+						timestamp = entry.timestamp
+					}
+					return value, true
+				}
+			}
+		}
+		return initial, true
+	}, func(key, value interface{}) {
+		cache.set(key, value, timestamp)
+	})
+	return result
 }
 
 // Get returns the value stored at `key`. The boolean value reports whether or
 // not the value was found. The OnExpiration callback is invoked if the value
 // had expired on access
 func (cache *Cache) Get(key interface{}) (interface{}, bool) {
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+	if cache.frozen.Load() {
+		key = cache.canonical(key)
+		cache.record(opGet, key, nil)
+		return cache.getFrozen(key)
+	}
+
+	if value, ok := cache.getShared(key); ok {
+		return value, true
+	}
+
+	cache.lock()
+	defer cache.unlock()
+
+	value, _, ok := cache.get(key)
+	return value, ok
+}
+
+// GetVersioned returns the value stored at `key` as Get would, along with its
+// version and whether or not it was found. Versions increase with every write
+// to the cache, so a key's version changes whenever it is set, and is never
+// zero for a present key. A value served from the overflow cache that could not
+// be stored in this one has version zero, as the key remains missing.
+func (cache *Cache) GetVersioned(key interface{}) (interface{}, uint64, bool) {
+	cache.lock()
+	defer cache.unlock()
+
+	value, entry, ok := cache.get(key)
+	if !ok {
+		return nil, 0, false
+	} else if entry == nil {
+		return value, 0, true
+	}
+
+	return value, entry.version, true
+}
+
+// SetIfVersion sets the key:value pair as Set would, only if the version of
+// the item at `key` is expectedVersion, as returned by GetVersioned. An
+// expectedVersion of zero only sets the key if it is missing or expired.
+// Returns whether or not the value was set.
+func (cache *Cache) SetIfVersion(key, value interface{}, expectedVersion uint64) bool {
+	return cache.setFunc(key, func(key interface{}) (interface{}, bool) {
+		var version uint64
+		if element, ok := cache.items[key]; ok {
+			if entry := element.Value.(*cacheEntry); !cache.expired(entry) {
+				version = entry.version
+			}
+		}
+		return value, version == expectedVersion
+	}, func(key, value interface{}) {
+		cache.set(key, value, cache.getTimestamp())
+	})
+}
+
+// GetWithFreshness returns the value stored at `key` as Get would, along with
+// whether or not it is fresh, i.e. set within config.SoftMaxAge, and whether or
+// not it was found. Stale values are still returned until they expire. A value
+// served from the overflow cache that could not be stored in this one is
+// reported as stale.
+func (cache *Cache) GetWithFreshness(key interface{}) (value interface{}, fresh bool, present bool) {
+	cache.lock()
+	defer cache.unlock()
+
+	value, entry, ok := cache.get(key)
+	if !ok {
+		return nil, false, false
+	} else if entry == nil {
+		return value, false, true
+	}
+
+	return value, cache.softMaxAge == 0 || time.Since(entry.timestamp) <= cache.softMaxAge, true
+}
+
+// GetManyReaping returns the unexpired values stored at `keys`, as Get would
+// for each key under a single lock. Expired entries encountered are deleted,
+// invoking the OnExpiration callback, and omitted from the result.
+func (cache *Cache) GetManyReaping(keys []interface{}) map[interface{}]interface{} {
+	cache.lock()
+	defer cache.unlock()
+
+	values := make(map[interface{}]interface{}, len(keys))
+	for _, key := range keys {
+		if value, _, ok := cache.get(key); ok {
+			values[key] = value
+		}
+	}
+
+	return values
+}
+
+// get implements Get, also returning the entry holding the value, or nil if
+// the value was served without being stored. The caller must hold the write
+// lock.
+func (cache *Cache) get(key interface{}) (interface{}, *cacheEntry, bool) {
+	key = cache.canonical(key)
+	cache.record(opGet, key, nil)
+	if cache.frozen.Load() {
+		value, ok := cache.getFrozen(key)
+		return value, nil, ok
+	}
 
-	cache.gets++
+	cache.count(&cache.gets)
 
 	if element, ok := cache.items[key]; ok {
 		entry := element.Value.(*cacheEntry)
-		if cache.maxAge == 0 || time.Since(entry.timestamp) <= cache.maxAge {
-			cache.evictionList.MoveToFront(element)
-			cache.hits++
-			return entry.value, true
+		if !cache.expired(entry) {
+			cache.touch(element)
+			cache.extendOnRead(entry)
+			cache.count(&cache.hits)
+			return entry.value, entry, true
 		}
 
 		// Entry expired
-		cache.deleteElement(element)
-		cache.misses++
+		cache.deleteElement(element, ReasonExpired)
+		cache.count(&cache.misses)
 		if cache.onExpiration != nil {
 			cache.onExpiration(entry.key, entry.value)
 		}
-		return nil, false
+		freeEntry(entry)
+		return nil, nil, false
 	}
 
-	cache.misses++
-	return nil, false
+	if cache.overflow != nil {
+		if value, taken, ok := cache.overflow.take(key, cache); ok {
+			if taken != nil {
+				cache.insert(taken.key, taken.value, taken.timestamp, taken.createdAt, false)
+				freeEntry(taken)
+			}
+			cache.count(&cache.hits)
+
+			// The item is left in the overflow cache if this one refuses
+			// it, e.g. at capacity with RejectNewOverflow
+			if element, ok := cache.items[key]; ok {
+				return value, element.Value.(*cacheEntry), true
+			}
+			return value, nil, true
+		}
+	}
+
+	cache.count(&cache.misses)
+	return nil, nil, false
 }
 
 // RefreshCache refreshes the entire cache with the new items map
 func (cache *Cache) RefreshCache(items map[interface{}]interface{}) {
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+	cache.lock()
+	defer cache.unlock()
+
+	cache.mustNotBeFrozen()
 
-	cache.items = make(map[interface{}]*list.Element)
+	for _, element := range cache.items {
+		freeEntry(cache.deleteElement(element, ReasonRemoved))
+	}
 	cache.evictionList.Init()
+	cache.clears++
 
 	for key, value := range items {
-		cache.sets++
-		timestamp := cache.getTimestamp()
-
-		if element, ok := cache.items[key]; ok {
-			cache.evictionList.MoveToFront(element)
-			entry := element.Value.(*cacheEntry)
-			entry.value = value
-			entry.timestamp = timestamp
+		key = cache.canonical(key)
+		if !cache.admit(key, value) {
+			continue
 		}
 
-		entry := &cacheEntry{key, value, timestamp}
-		element := cache.evictionList.PushFront(entry)
-		cache.items[key] = element
-
-		evict := cache.evictionList.Len() > cache.capacity
-		if evict {
-			cache.evictOldest()
-		}
+		cache.countSet(key)
+		cache.set(key, value, cache.getTimestamp())
 	}
 }
 
 // Has returns whether or not the `key` is in the cache without updating
-// how recently it was accessed or deleting it for having expired.
+// how recently it was accessed or deleting it for having expired. If
+// config.HasReapsExpired is set, an expired entry is instead deleted, invoking
+// the OnExpiration callback, and reported as missing.
 func (cache *Cache) Has(key interface{}) bool {
+	if cache.frozen.Load() {
+		_, ok := cache.items[cache.canonical(key)]
+		return ok
+	}
+
 	cache.mutex.RLock()
-	defer cache.mutex.RUnlock()
+	key = cache.canonical(key)
+	element, ok := cache.items[key]
+	reap := ok && cache.hasReapsExpired && cache.expired(element.Value.(*cacheEntry))
+	cache.mutex.RUnlock()
 
-	_, ok := cache.items[key]
+	if reap {
+		return cache.hasReaping(key)
+	}
 	return ok
 }
 
+func (cache *Cache) hasReaping(key interface{}) bool {
+	cache.lock()
+	defer cache.unlock()
+
+	if cache.frozen.Load() {
+		_, ok := cache.items[key]
+		return ok
+	}
+
+	element, ok := cache.items[key]
+	if !ok {
+		return false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !cache.expired(entry) {
+		return true
+	}
+
+	cache.deleteElement(element, ReasonExpired)
+	if cache.onExpiration != nil {
+		cache.onExpiration(entry.key, entry.value)
+	}
+	freeEntry(entry)
+	return false
+}
+
 // Peek returns the value at the specified key and a boolean specifying whether
 // or not it was found, without updating how recently it was accessed or
 // deleting it for having expired.
 func (cache *Cache) Peek(key interface{}) (interface{}, bool) {
-	cache.mutex.RLock()
-	defer cache.mutex.RUnlock()
+	if !cache.frozen.Load() {
+		cache.mutex.RLock()
+		defer cache.mutex.RUnlock()
+	}
 
-	if element, ok := cache.items[key]; ok {
+	if element, ok := cache.items[cache.canonical(key)]; ok {
 		return element.Value.(*cacheEntry).value, true
 	}
 
 	return nil, false
 }
 
-// Remove removes the provided key from the cache, returning a bool indicating
-// whether or not it existed.
-func (cache *Cache) Remove(key interface{}) bool {
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+// GetWithContext behaves like Get, additionally reporting the operation and
+// its outcome to the configured Tracer with the given context.
+func (cache *Cache) GetWithContext(ctx context.Context, key interface{}) (interface{}, bool) {
+	value, ok := cache.Get(key)
 
-	if element, ok := cache.items[key]; ok {
-		cache.deleteElement(element)
-		return true
-	}
+	cache.mutex.RLock()
+	tracer := cache.tracer
+	cache.mutex.RUnlock()
 
-	return false
+	if tracer != nil {
+		tracer(ctx, "get", ok)
+	}
+	return value, ok
 }
 
-// EvictOldest removes the oldest item from the cache, while also invoking any
-// eviction callback. A bool is returned indicating whether or not an item was
-// removed
-func (cache *Cache) EvictOldest() bool {
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+// WaitFor returns the value stored at `key` as Get would if it is present.
+// Otherwise, it blocks until the key is next set or ctx is done, returning the
+// value set and true, or nil and false if ctx was done first.
+func (cache *Cache) WaitFor(ctx context.Context, key interface{}) (interface{}, bool) {
+	cache.lock()
+	if value, _, ok := cache.get(key); ok {
+		cache.unlock()
+		return value, true
+	}
 
-	return cache.evictOldest()
+	key = cache.canonical(key)
+	waiter := make(chan interface{}, 1)
+	cache.waiters[key] = append(cache.waiters[key], waiter)
+	cache.unlock()
+
+	select {
+	case value := <-waiter:
+		return value, true
+	case <-ctx.Done():
+	}
+
+	cache.lock()
+	defer cache.unlock()
+
+	waiters := cache.waiters[key]
+	for i := range waiters {
+		if waiters[i] == waiter {
+			cache.waiters[key] = append(waiters[:i:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(cache.waiters[key]) == 0 {
+		delete(cache.waiters, key)
+	}
+
+	// The key may have been set after ctx was done
+	select {
+	case value := <-waiter:
+		return value, true
+	default:
+		return nil, false
+	}
 }
 
-// Len returns the number of items in the cache.
-func (cache *Cache) Len() int {
+// GetStale returns the value stored at `key` even if it has expired, along
+// with whether or not it was stale and whether or not it was found. Unlike
+// Get, expired entries are not deleted and the OnExpiration callback is not
+// invoked, and how recently the entry was accessed is not updated.
+func (cache *Cache) GetStale(key interface{}) (value interface{}, stale bool, present bool) {
 	cache.mutex.RLock()
 	defer cache.mutex.RUnlock()
 
-	return cache.evictionList.Len()
+	if element, ok := cache.items[cache.canonical(key)]; ok {
+		entry := element.Value.(*cacheEntry)
+		return entry.value, cache.expired(entry), true
+	}
+
+	return nil, false, false
 }
 
-// Clear empties the cache.
-func (cache *Cache) Clear() {
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+// CreatedAt returns the time at which the entry at `key` was first set, which
+// overwrites do not reset, and a boolean specifying whether or not it was
+// found. Like Peek, it does not update how recently the entry was accessed or
+// delete it for having expired.
+func (cache *Cache) CreatedAt(key interface{}) (time.Time, bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
 
-	for _, val := range cache.items {
-		cache.deleteElement(val)
+	element, ok := cache.items[cache.canonical(key)]
+	if !ok {
+		return time.Time{}, false
 	}
-	cache.evictionList.Init()
+	return element.Value.(*cacheEntry).createdAt, true
 }
 
-// Keys returns all keys in the cache.
-func (cache *Cache) Keys() []interface{} {
+// ExpiresAt returns the time at which the entry at `key` expires, taking any
+// jitter applied on Set, MaxLifetime and DeadlineFunc into account, and a
+// boolean specifying whether or not it was found. The zero time is returned
+// when expiration is disabled. Like Peek, it does not update how recently the
+// entry was accessed or delete it for having expired.
+func (cache *Cache) ExpiresAt(key interface{}) (time.Time, bool) {
 	cache.mutex.RLock()
 	defer cache.mutex.RUnlock()
 
-	keys := make([]interface{}, len(cache.items))
-	i := 0
+	element, ok := cache.items[cache.canonical(key)]
+	if !ok {
+		return time.Time{}, false
+	}
 
-	for key := range cache.items {
-		keys[i] = key
-		i++
+	var expiresAt time.Time
+	entry := element.Value.(*cacheEntry)
+	if cache.maxAge > 0 {
+		expiresAt = entry.timestamp.Add(cache.maxAge)
+	}
+	if cache.maxLifetime > 0 {
+		if end := entry.createdAt.Add(cache.maxLifetime); expiresAt.IsZero() || end.Before(expiresAt) {
+			expiresAt = end
+		}
+	}
+	if !entry.deadline.IsZero() && (expiresAt.IsZero() || entry.deadline.Before(expiresAt)) {
+		expiresAt = entry.deadline
 	}
 
-	return keys
+	return expiresAt, true
 }
 
-// OrderedKeys returns all keys in the cache, ordered from oldest to newest.
-func (cache *Cache) OrderedKeys() []interface{} {
-	cache.mutex.RLock()
-	defer cache.mutex.RUnlock()
+// Remove removes the provided key from the cache, returning a bool indicating
+// whether or not it existed.
+func (cache *Cache) Remove(key interface{}) bool {
+	cache.lock()
+	defer cache.unlock()
 
-	keys := make([]interface{}, len(cache.items))
-	i := 0
+	cache.mustNotBeFrozen()
 
-	for element := cache.evictionList.Back(); element != nil; element = element.Prev() {
-		keys[i] = element.Value.(*cacheEntry).key
-		i++
+	if element, ok := cache.items[cache.canonical(key)]; ok {
+		freeEntry(cache.deleteElement(element, ReasonRemoved))
+		return true
 	}
 
-	return keys
+	return false
 }
 
-// SetMaxAge updates the max age for items in the cache. A duration of zero
-// disables expiration. A negative duration, or one that is less than minAge,
-// results in an error.
-func (cache *Cache) SetMaxAge(maxAge time.Duration) error {
-	if maxAge < 0 {
-		return errors.New("Must supply a zero or positive maxAge")
-	} else if maxAge < cache.minAge {
-		return errors.New("Must supply a maxAge greater than or equal to minAge")
-	}
+// RemoveMany removes the provided keys from the cache under a single lock,
+// returning those that existed. Removal callbacks are invoked as for Remove.
+func (cache *Cache) RemoveMany(keys []interface{}) []interface{} {
+	cache.lock()
+	defer cache.unlock()
 
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+	cache.mustNotBeFrozen()
 
-	cache.maxAge = maxAge
+	var removed []interface{}
+	for _, key := range keys {
+		if element, ok := cache.items[cache.canonical(key)]; ok {
+			freeEntry(cache.deleteElement(element, ReasonRemoved))
+			removed = append(removed, key)
+		}
+	}
 
-	return nil
+	return removed
 }
 
-// SetMinAge updates the min age for items in the cache. A duration of zero
-// or equal to maxAge disables jitter. A negative duration, or one that is
-// greater than maxAge, results in an error.
-func (cache *Cache) SetMinAge(minAge time.Duration) error {
-	if minAge < 0 {
-		return errors.New("Must supply a zero or positive minAge")
-	} else if minAge > cache.maxAge {
-		return errors.New("Must supply a minAge lesser than or equal to maxAge")
+// Rename moves the item at oldKey to newKey, preserving its value, timestamp
+// and how recently it was accessed, and replacing any item at newKey. Returns
+// whether or not oldKey existed. Callbacks registered with OnKeyRemoved are not
+// invoked for oldKey. Items depending on oldKey, as set by SetWithDeps, are
+// removed as if it were, and the renamed item keeps no dependencies. If the
+// item moves into a group at MaxPerGroup, the oldest item of the group is
+// evicted, as on Set.
+func (cache *Cache) Rename(oldKey, newKey interface{}) bool {
+	cache.lock()
+	defer cache.unlock()
+
+	cache.mustNotBeFrozen()
+
+	oldKey, newKey = cache.canonical(oldKey), cache.canonical(newKey)
+	element, ok := cache.items[oldKey]
+	if !ok || oldKey == newKey {
+		return ok
 	}
 
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+	cache.record(opRename, oldKey, newKey)
+
+	if existing, ok := cache.items[newKey]; ok {
+		freeEntry(cache.deleteElement(existing, ReasonRemoved))
+		if cache.items[oldKey] != element {
+			// The item depended on the one replaced, and was removed with it
+			return true
+		}
+	}
+
+	entry := element.Value.(*cacheEntry)
+	delete(cache.items, oldKey)
+	cache.undepend(entry)
+	cache.removeDependents(oldKey)
+	entry.key = newKey
+	cache.items[newKey] = element
+
+	if cache.groupFunc != nil {
+		cache.ungroup(entry.group)
+		group := cache.groupFunc(newKey)
+		if cache.maxPerGroup > 0 && cache.groups[group] >= cache.maxPerGroup && cache.evictionDisabled == 0 {
+			cache.evictOldestInGroup(group)
+		}
+		entry.group = group
+		cache.groups[entry.group]++
+	}
+
+	return true
+}
+
+// TrimExpired removes all expired items from the cache, invoking the
+// expiration callbacks as the active expiration sweep would. Returns the number
+// of items removed and, if a CostFunc is configured, their total cost, e.g. the
+// bytes reclaimed.
+func (cache *Cache) TrimExpired() (entries int, bytes int64) {
+	return cache.deleteExpired()
+}
+
+// EvictOldest removes the oldest item from the cache, while also invoking any
+// eviction callback. A bool is returned indicating whether or not an item was
+// removed
+func (cache *Cache) EvictOldest() bool {
+	cache.lock()
+	defer cache.unlock()
+
+	cache.mustNotBeFrozen()
+
+	return cache.evictOldest()
+}
+
+// Len returns the number of items in the cache.
+func (cache *Cache) Len() int {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.evictionList.Len()
+}
+
+// Clear empties the cache.
+func (cache *Cache) Clear() {
+	cache.lock()
+	defer cache.unlock()
+
+	cache.mustNotBeFrozen()
+
+	for _, val := range cache.items {
+		freeEntry(cache.deleteElement(val, ReasonRemoved))
+	}
+	cache.evictionList.Init()
+	cache.clears++
+}
+
+// DrainFunc removes all items from the cache, oldest first, handing them to
+// fn in batches of at most batchSize entries. Expired items are not handed to
+// fn; they are deleted, invoking the OnExpiration callback. The removal
+// callbacks are invoked for the items in a batch once fn accepts it. If fn
+// returns an error, draining stops: the items in the failed batch are restored
+// as the oldest in the cache without invoking the removal callbacks, unless
+// their key was set in the meantime, the remaining items are left in place,
+// and the error is returned. Restored items beyond the capacity or MaxCost are
+// then evicted as usual, and those of a cache frozen meanwhile are removed.
+// fn is invoked outside of the cache lock.
+func (cache *Cache) DrainFunc(batchSize int, fn func(entries []Entry) error) error {
+	if batchSize <= 0 {
+		return errors.New("Must supply a positive batchSize to DrainFunc")
+	} else if cache.frozen.Load() {
+		return ErrFrozen
+	}
+
+	for {
+		drained := cache.drain(batchSize)
+		if len(drained) == 0 {
+			return nil
+		}
+
+		batch := make([]Entry, len(drained))
+		for i, entry := range drained {
+			batch[i] = Entry{Key: entry.key, Value: entry.value}
+		}
+
+		if err := fn(batch); err != nil {
+			cache.restore(drained)
+			return err
+		}
+		cache.commit(drained)
+	}
+}
+
+// drain removes up to n unexpired items from the back of the eviction list,
+// returning their entries ordered from oldest to newest. The removal callbacks
+// are not invoked until the entries are committed, and the entries keep their
+// dependencies in case they are restored.
+func (cache *Cache) drain(n int) []*cacheEntry {
+	cache.lock()
+	defer cache.unlock()
+
+	if cache.frozen.Load() {
+		return nil
+	}
+
+	drained := make([]*cacheEntry, 0, n)
+	for len(drained) < n {
+		element := cache.evictionList.Back()
+		if element == nil {
+			break
+		}
+
+		entry := element.Value.(*cacheEntry)
+		if cache.expired(entry) {
+			cache.deleteElement(element, ReasonExpired)
+			if cache.onExpiration != nil {
+				cache.onExpiration(entry.key, entry.value)
+			}
+			freeEntry(entry)
+			continue
+		}
+
+		cache.unlink(element)
+		dependsOn := entry.dependsOn
+		cache.undepend(entry)
+		entry.dependsOn = dependsOn
+		drained = append(drained, entry)
+	}
+
+	cache.checkFull()
+	return drained
+}
+
+// commit completes the removal of entries drained by drain, invoking the
+// removal callbacks, and returns the entries to the pool.
+func (cache *Cache) commit(entries []*cacheEntry) {
+	cache.lock()
+	defer cache.unlock()
+
+	for _, entry := range entries {
+		cache.commitEntry(entry)
+	}
+}
+
+// commitEntry completes the removal of a drained entry. If its key was set
+// since, the entry was superseded rather than removed, so no callbacks are
+// invoked.
+func (cache *Cache) commitEntry(entry *cacheEntry) {
+	if _, ok := cache.items[entry.key]; !ok {
+		cache.notifyRemoved(entry, ReasonRemoved)
+	}
+	freeEntry(entry)
+}
+
+// restore puts back entries removed by drain as the oldest in the cache, along
+// with their dependencies, evicting items as needed to fit the capacity and
+// MaxCost.
+func (cache *Cache) restore(entries []*cacheEntry) {
+	cache.lock()
+	defer cache.unlock()
+
+	if cache.frozen.Load() {
+		for _, entry := range entries {
+			cache.commitEntry(entry)
+		}
+		return
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if _, ok := cache.items[entry.key]; ok {
+			freeEntry(entry)
+			continue
+		}
+
+		cache.items[entry.key] = cache.evictionList.PushBack(entry)
+		cache.cost += entry.cost
+		if cache.groupFunc != nil {
+			cache.groups[entry.group]++
+		}
+		dependsOn := entry.dependsOn
+		entry.dependsOn = nil
+		cache.depend(entry, dependsOn)
+	}
+
+	if cache.evictionDisabled == 0 {
+		cache.evictToFit(ReasonEvicted)
+	}
+	cache.evictOverCost()
+	cache.checkFull()
+}
+
+// Freeze makes the cache immutable. Background expiration and refresh are
+// stopped, and from then on Get, Peek and Has are served without locking.
+// Frozen reads do not record stats, update how recently items were accessed,
+// or delete expired items, though expired items are still reported as missing
+// by Get. Methods modifying the cache panic or return ErrFrozen afterwards.
+func (cache *Cache) Freeze() {
+	cache.lock()
+	defer cache.unlock()
+
+	cache.stopBackground()
+	cache.frozen.Store(true)
+}
+
+// Keys returns all keys in the cache.
+func (cache *Cache) Keys() []interface{} {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.keys()
+}
+
+func (cache *Cache) keys() []interface{} {
+	keys := make([]interface{}, len(cache.items))
+	i := 0
+
+	for key := range cache.items {
+		keys[i] = key
+		i++
+	}
+
+	return keys
+}
+
+// OrderedKeys returns all keys in the cache, ordered from oldest to newest.
+func (cache *Cache) OrderedKeys() []interface{} {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	keys := make([]interface{}, len(cache.items))
+	i := 0
+
+	for element := cache.evictionList.Back(); element != nil; element = element.Prev() {
+		keys[i] = element.Value.(*cacheEntry).key
+		i++
+	}
+
+	return keys
+}
+
+// ForEachLRU invokes fn for every item in the cache, including expired ones,
+// with its key, value and stored timestamp, in eviction order from oldest to
+// newest, or newest to oldest if fromNewest is set. It stops early if fn
+// returns false. fn is invoked under the read lock and must not modify the
+// cache.
+func (cache *Cache) ForEachLRU(fromNewest bool, fn func(key, value interface{}, timestamp time.Time) bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	element, next := cache.evictionList.Back(), (*list.Element).Prev
+	if fromNewest {
+		element, next = cache.evictionList.Front(), (*list.Element).Next
+	}
+
+	for ; element != nil; element = next(element) {
+		entry := element.Value.(*cacheEntry)
+		if !fn(entry.key, entry.value, entry.timestamp) {
+			return
+		}
+	}
+}
+
+// OldestN invokes fn for up to n of the oldest unexpired items in the cache,
+// from oldest to newest, stopping early if fn returns false. fn is invoked
+// under the read lock and must not modify the cache.
+func (cache *Cache) OldestN(n int, fn func(key, value interface{}) bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	for element := cache.evictionList.Back(); element != nil && n > 0; element = element.Prev() {
+		entry := element.Value.(*cacheEntry)
+		if cache.expired(entry) {
+			continue
+		}
+
+		n--
+		if !fn(entry.key, entry.value) {
+			return
+		}
+	}
+}
+
+// ByLastAccess returns the unexpired items in the cache, ordered from most to
+// least recently set or read.
+func (cache *Cache) ByLastAccess() []EntryInfo {
+	infos := cache.entryInfos()
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].LastAccess.After(infos[j].LastAccess)
+	})
+	return infos
+}
+
+// TopByCost returns up to n of the unexpired items in the cache with the
+// highest cost, from most to least costly, e.g. to find the items dominating a
+// MaxCost budget. Items of equal cost are ordered from most to least recently
+// used. Costs are zero unless a CostFunc is configured.
+func (cache *Cache) TopByCost(n int) []EntryInfo {
+	if n <= 0 {
+		return nil
+	}
+
+	infos := cache.entryInfos()
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Cost > infos[j].Cost
+	})
+	if len(infos) > n {
+		infos = infos[:n]
+	}
+	return infos
+}
+
+// entryInfos returns the unexpired items in the cache, from most to least
+// recently used.
+func (cache *Cache) entryInfos() []EntryInfo {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	infos := make([]EntryInfo, 0, cache.evictionList.Len())
+	for element := cache.evictionList.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*cacheEntry)
+		if cache.expired(entry) {
+			continue
+		}
+
+		infos = append(infos, EntryInfo{
+			Key:        entry.key,
+			Value:      entry.value,
+			Timestamp:  entry.timestamp,
+			LastAccess: entry.accessedAt(),
+			Cost:       entry.cost,
+		})
+	}
+
+	return infos
+}
+
+// FindByValue returns the keys of the unexpired items in the cache whose value
+// satisfies pred, without modifying the cache. pred is invoked under the read
+// lock and must not modify the cache.
+func (cache *Cache) FindByValue(pred func(value interface{}) bool) []interface{} {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	var keys []interface{}
+	for key, element := range cache.items {
+		entry := element.Value.(*cacheEntry)
+		if !cache.expired(entry) && pred(entry.value) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// UpdateFunc replaces the value of every unexpired item in the cache whose
+// key:value pair satisfies pred with the result of update, as a single atomic
+// operation under the write lock. Timestamps and recency are left unchanged,
+// and costs and DeadlineFunc deadlines are recomputed, evicting the oldest items
+// if MaxCost is then exceeded. Items whose new value is rejected by ShouldCache
+// or the size limits are removed instead, as Set would remove them. OnChange is
+// invoked for changed values once the lock is released.
+// pred and update must not modify the cache. Returns the number of items
+// updated.
+func (cache *Cache) UpdateFunc(pred func(key, value interface{}) bool, update func(value interface{}) interface{}) int {
+	var notifies []func()
+	defer func() {
+		for _, notify := range notifies {
+			notify()
+		}
+	}()
+
+	cache.lock()
+	defer cache.unlock()
+
+	cache.mustNotBeFrozen()
+
+	updated := 0
+	var rejected []interface{}
+	for element := cache.evictionList.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*cacheEntry)
+		if cache.expired(entry) || !pred(entry.key, entry.value) {
+			continue
+		}
+
+		value := update(entry.value)
+		if !cache.admissible(entry.key, value) {
+			// Removed once done, as removing dependents could unlink the
+			// elements still to visit
+			rejected = append(rejected, entry.key)
+			continue
+		}
+
+		cache.record(opUpdate, entry.key, value)
+		if notify := cache.change(entry.key, value); notify != nil {
+			notifies = append(notifies, notify)
+		}
+		entry.value = value
+		cache.price(entry)
+		cache.setDeadline(entry)
+		cache.version++
+		entry.version = cache.version
+		updated++
+	}
+
+	for _, key := range rejected {
+		if element, ok := cache.items[key]; ok {
+			freeEntry(cache.deleteElement(element, ReasonRemoved))
+		}
+	}
+
+	cache.evictOverCost()
+	return updated
+}
+
+// FindByType returns the keys of the unexpired items in the cache whose value
+// has the same dynamic type as target, e.g. FindByType(&User{}) for values of
+// type *User. A reflect.Type may be passed to match values of that type.
+func (cache *Cache) FindByType(target interface{}) []interface{} {
+	typ, ok := target.(reflect.Type)
+	if !ok {
+		typ = reflect.TypeOf(target)
+	}
+
+	return cache.FindByValue(func(value interface{}) bool {
+		return reflect.TypeOf(value) == typ
+	})
+}
+
+// AgePercentiles returns the requested percentiles, from 0 to 100, of the age
+// of the unexpired items in the cache, using the nearest-rank method. Ages are
+// measured from the timestamps used for expiration, including any jitter. An
+// empty map is returned if the cache holds no unexpired items.
+func (cache *Cache) AgePercentiles(ps ...float64) map[float64]time.Duration {
+	cache.mutex.RLock()
+	ages := make([]time.Duration, 0, cache.evictionList.Len())
+	for element := cache.evictionList.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*cacheEntry)
+		if !cache.expired(entry) {
+			ages = append(ages, time.Since(entry.timestamp))
+		}
+	}
+	cache.mutex.RUnlock()
+
+	percentiles := make(map[float64]time.Duration, len(ps))
+	if len(ages) == 0 {
+		return percentiles
+	}
+
+	sort.Slice(ages, func(i, j int) bool { return ages[i] < ages[j] })
+	for _, p := range ps {
+		rank := int(math.Ceil(p / 100 * float64(len(ages))))
+		if rank < 1 {
+			rank = 1
+		} else if rank > len(ages) {
+			rank = len(ages)
+		}
+		percentiles[p] = ages[rank-1]
+	}
+
+	return percentiles
+}
+
+// CountFunc returns the number of unexpired items in the cache for which
+// pred returns true, without materializing the keys.
+func (cache *Cache) CountFunc(pred func(key, value interface{}) bool) int {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	count := 0
+	for _, element := range cache.items {
+		entry := element.Value.(*cacheEntry)
+		if !cache.expired(entry) && pred(entry.key, entry.value) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// EntriesSince returns the unexpired items in the cache set at or after t,
+// from least to most recently used. Items are compared by their stored
+// timestamp which, when MinAge is less than MaxAge, is jittered back by up to
+// MaxAge - MinAge, so items set shortly after t may be omitted.
+func (cache *Cache) EntriesSince(t time.Time) []Entry {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	var entries []Entry
+	for element := cache.evictionList.Back(); element != nil; element = element.Prev() {
+		entry := element.Value.(*cacheEntry)
+		if !entry.timestamp.Before(t) && !cache.expired(entry) {
+			entries = append(entries, Entry{Key: entry.key, Value: entry.value})
+		}
+	}
+
+	return entries
+}
+
+// SetMaxAge updates the max age for items in the cache. A duration of zero
+// disables expiration. A negative duration, or one that is less than minAge,
+// results in an error, or a panic in strict mode.
+func (cache *Cache) SetMaxAge(maxAge time.Duration) error {
+	cache.lock()
+	defer cache.unlock()
+
+	if maxAge < 0 {
+		return cache.invalid(errors.New("Must supply a zero or positive maxAge"))
+	} else if maxAge < cache.minAge {
+		return cache.invalid(errors.New("Must supply a maxAge greater than or equal to minAge"))
+	} else if cache.frozen.Load() {
+		return ErrFrozen
+	}
+
+	cache.maxAge = maxAge
+
+	return nil
+}
+
+// SetMinAge updates the min age for items in the cache. A duration of zero
+// or equal to maxAge disables jitter. A negative duration, or one that is
+// greater than maxAge, results in an error, or a panic in strict mode.
+func (cache *Cache) SetMinAge(minAge time.Duration) error {
+	cache.lock()
+	defer cache.unlock()
+
+	if minAge < 0 {
+		return cache.invalid(errors.New("Must supply a zero or positive minAge"))
+	} else if minAge > cache.maxAge {
+		return cache.invalid(errors.New("Must supply a minAge lesser than or equal to maxAge"))
+	} else if cache.frozen.Load() {
+		return ErrFrozen
+	}
 
 	if minAge == 0 {
 		cache.minAge = cache.maxAge
@@ -419,104 +1680,1530 @@ func (cache *Cache) SetMinAge(minAge time.Duration) error {
 		cache.minAge = minAge
 	}
 
-	return nil
+	return nil
+}
+
+// OnEviction sets the eviction callback.
+func (cache *Cache) OnEviction(callback func(key, value interface{})) {
+	cache.lock()
+	defer cache.unlock()
+
+	cache.onEviction = callback
+}
+
+// OnExpiration sets the expiration callback.
+func (cache *Cache) OnExpiration(callback func(key, value interface{})) {
+	cache.lock()
+	defer cache.unlock()
+
+	cache.onExpiration = callback
+}
+
+// OnKeyRemoved registers a callback invoked with the value and the reason when
+// `key` leaves the cache. A callback that isn't persistent is invoked at most
+// once and then discarded; a persistent callback stays registered across the
+// key being removed and set again.
+func (cache *Cache) OnKeyRemoved(key interface{}, persistent bool, fn func(value interface{}, reason RemoveReason)) {
+	cache.lock()
+	defer cache.unlock()
+
+	key = cache.canonical(key)
+	cache.keyCallbacks[key] = append(cache.keyCallbacks[key], keyCallback{fn, persistent})
+}
+
+// Stats returns cache stats.
+func (cache *Cache) Stats() Stats {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.stats()
+}
+
+// ObserveDelta writes the stats since prev into out, as Stats().Delta(*prev)
+// would, and updates prev to the current stats for the next call, without
+// allocating.
+func (cache *Cache) ObserveDelta(prev *Stats, out *Stats) {
+	cache.mutex.RLock()
+	current := cache.stats()
+	cache.mutex.RUnlock()
+
+	*out = current.Delta(*prev)
+	*prev = current
+}
+
+// StatsExtended returns cache stats along with derived metrics, computed
+// consistently under the read lock.
+func (cache *Cache) StatsExtended() StatsExtended {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	stats := StatsExtended{Stats: cache.stats()}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+	if stats.Capacity > 0 {
+		stats.FillRatio = float64(stats.Count) / float64(stats.Capacity)
+	}
+	if stats.Sets > 0 {
+		stats.EvictionsPerSet = float64(stats.Evictions) / float64(stats.Sets)
+	}
+
+	var oldest time.Time
+	for element := cache.evictionList.Front(); element != nil; element = element.Next() {
+		if timestamp := element.Value.(*cacheEntry).timestamp; oldest.IsZero() || timestamp.Before(oldest) {
+			oldest = timestamp
+		}
+	}
+	if !oldest.IsZero() {
+		stats.OldestAge = time.Since(oldest)
+	}
+
+	return stats
+}
+
+// StatsJSON returns StatsExtended encoded as JSON.
+func (cache *Cache) StatsJSON() ([]byte, error) {
+	return json.Marshal(cache.StatsExtended())
+}
+
+func (cache *Cache) stats() Stats {
+	stats := Stats{
+		Capacity:      int64(cache.capacity),
+		Count:         int64(cache.evictionList.Len()),
+		Sets:          cache.sets,
+		Inserts:       cache.inserts,
+		Overwrites:    cache.overwrites,
+		Gets:          atomic.LoadInt64(&cache.gets),
+		Hits:          atomic.LoadInt64(&cache.hits),
+		Misses:        atomic.LoadInt64(&cache.misses),
+		Evictions:     cache.evictions,
+		Rejections:    cache.rejections,
+		SkippedBySize: cache.skippedBySize,
+		Throttled:     cache.throttled,
+		Cost:          cache.cost,
+		MaxCost:       cache.maxCost,
+		Name:          cache.name,
+
+		MinEvictionAge: cache.evictionAgeMin,
+		MaxEvictionAge: cache.evictionAgeMax,
+	}
+	if cache.lruEvictions > 0 {
+		stats.AvgEvictionAge = cache.evictionAgeTotal / time.Duration(cache.lruEvictions)
+	}
+	return stats
+}
+
+// Name returns the name of the cache, if configured.
+func (cache *Cache) Name() string {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.name
+}
+
+// LockStats returns write lock instrumentation. It is zero unless
+// config.TrackLockHold is set.
+func (cache *Cache) LockStats() LockStats {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	stats := LockStats{
+		Acquisitions: cache.lockHolds,
+		MaxLockHold:  cache.lockHoldMax,
+	}
+	if cache.lockHolds > 0 {
+		stats.AvgLockHold = cache.lockHoldTotal / time.Duration(cache.lockHolds)
+	}
+	return stats
+}
+
+// Resize the cache to hold at most n entries. If n is smaller than the current
+// size, entries are evicted to fit the new size. It errors, or panics in
+// strict mode, if n <= 0.
+func (cache *Cache) Resize(n int) error {
+	cache.lock()
+	defer cache.unlock()
+
+	if n <= 0 {
+		return cache.invalid(errors.New("must supply a positive capacity to Resize"))
+	} else if cache.frozen.Load() {
+		return ErrFrozen
+	}
+
+	cache.resize(n)
+	return nil
+}
+
+// Grow increases the capacity of the cache by extra entries, e.g. ahead of a
+// bulk insert so that it does not evict existing entries. It errors, or panics
+// in strict mode, if extra <= 0.
+func (cache *Cache) Grow(extra int) error {
+	cache.lock()
+	defer cache.unlock()
+
+	if extra <= 0 {
+		return cache.invalid(errors.New("must supply a positive number of entries to Grow"))
+	} else if cache.frozen.Load() {
+		return ErrFrozen
+	}
+
+	cache.resize(cache.capacity + extra)
+	return nil
+}
+
+// Shrink decreases the capacity of the cache by n entries, e.g. to undo Grow.
+// If the cache then holds more entries than its capacity, the oldest are
+// evicted to fit, with ReasonResized. It errors, or panics in strict mode, if
+// n <= 0 or the capacity would not remain positive.
+func (cache *Cache) Shrink(n int) error {
+	cache.lock()
+	defer cache.unlock()
+
+	if n <= 0 || n >= cache.capacity {
+		return cache.invalid(errors.New("must supply a positive number of entries less than the capacity to Shrink"))
+	} else if cache.frozen.Load() {
+		return ErrFrozen
+	}
+
+	cache.resize(cache.capacity - n)
+	return nil
+}
+
+// TrimToPercent evicts the oldest items until at most percent of the items in
+// the cache remain, e.g. 75 to shed a quarter of them under memory pressure,
+// invoking the eviction callbacks. Returns the number of items evicted. It
+// errors, or panics in strict mode, if percent is not between 0 and 100.
+func (cache *Cache) TrimToPercent(percent float64) (int, error) {
+	cache.lock()
+	defer cache.unlock()
+
+	if percent < 0 || percent > 100 {
+		return 0, cache.invalid(errors.New("must supply a percent between 0 and 100 to TrimToPercent"))
+	} else if cache.frozen.Load() {
+		return 0, ErrFrozen
+	}
+
+	keep := int(float64(cache.evictionList.Len()) * percent / 100)
+	evicted := 0
+	for cache.evictionList.Len() > keep {
+		cache.evictOldestEntry(ReasonEvicted)
+		evicted++
+	}
+	cache.checkFull()
+	return evicted, nil
+}
+
+// resize sets the capacity to n, evicting entries to fit. The caller must hold
+// the write lock.
+func (cache *Cache) resize(n int) {
+	cache.capacity = n
+	cache.evictToFit(ReasonResized)
+	cache.checkFull()
+}
+
+// DisableEviction suspends the eviction of items due to capacity, MaxCost and
+// MaxPerGroup until a matching call to EnableEviction. Calls may be nested.
+// Meanwhile, the cache grows beyond its capacity without bound as new keys are
+// set, so the window should be kept short.
+func (cache *Cache) DisableEviction() {
+	cache.lock()
+	defer cache.unlock()
+
+	cache.evictionDisabled++
+}
+
+// EnableEviction resumes eviction suspended by DisableEviction, evicting the
+// oldest items down to the capacity, MaxCost and MaxPerGroup once the last call
+// is matched. A cache frozen meanwhile is left as is.
+func (cache *Cache) EnableEviction() {
+	cache.lock()
+	defer cache.unlock()
+
+	if cache.evictionDisabled == 0 {
+		return
+	}
+
+	cache.evictionDisabled--
+	if cache.evictionDisabled == 0 && !cache.frozen.Load() {
+		cache.evictGroupsToFit()
+		cache.evictToFit(ReasonEvicted)
+		cache.evictOverCost()
+		cache.checkFull()
+	}
+}
+
+// WithEvictionDisabled invokes fn with eviction disabled, as by
+// DisableEviction, enabling it again once fn returns.
+func (cache *Cache) WithEvictionDisabled(fn func()) {
+	cache.DisableEviction()
+	defer cache.EnableEviction()
+
+	fn()
+}
+
+// invalid reports a validation error, panicking with it in strict mode. The
+// caller must hold the lock.
+func (cache *Cache) invalid(err error) error {
+	if cache.strict {
+		panic(err.Error())
+	}
+	return err
+}
+
+// lock acquires the write lock, noting when it was acquired if lock hold
+// tracking is enabled.
+func (cache *Cache) lock() {
+	cache.mutex.Lock()
+	if cache.trackLockHold {
+		cache.lockedAt = time.Now()
+	}
+}
+
+// unlock records how long the write lock was held, if lock hold tracking is
+// enabled, and releases it.
+func (cache *Cache) unlock() {
+	if cache.trackLockHold && !cache.lockedAt.IsZero() {
+		held := time.Since(cache.lockedAt)
+		cache.lockHolds++
+		cache.lockHoldTotal += held
+		if held > cache.lockHoldMax {
+			cache.lockHoldMax = held
+		}
+	}
+	cache.lockedAt = time.Time{}
+	cache.mutex.Unlock()
+}
+
+// getShared serves a hit under the read lock when eviction is sampled, as a
+// read then only updates the access time of the item and the stats, which are
+// both atomic. It returns false for the caller to fall back to get under the
+// write lock on a miss, an expired item, or when reads also extend items or
+// observe a stats window.
+func (cache *Cache) getShared(key interface{}) (interface{}, bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	if cache.sampleSize == 0 || cache.extendOnReadWithin > 0 || cache.window != nil {
+		return nil, false
+	}
+
+	key = cache.canonical(key)
+	element, ok := cache.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if cache.expired(entry) {
+		return nil, false
+	}
+
+	cache.record(opGet, key, nil)
+	entry.lastAccess.Store(time.Now().UnixNano())
+	if !cache.statsDisabled {
+		atomic.AddInt64(&cache.gets, 1)
+		atomic.AddInt64(&cache.hits, 1)
+	}
+	return entry.value, true
+}
+
+// getFrozen looks up a key in a frozen cache without locking or modifying it.
+func (cache *Cache) getFrozen(key interface{}) (interface{}, bool) {
+	if element, ok := cache.items[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if !cache.expired(entry) {
+			return entry.value, true
+		}
+	}
+
+	return nil, false
+}
+
+// mustNotBeFrozen panics with ErrFrozen if the cache is frozen. The caller
+// must hold the write lock.
+func (cache *Cache) mustNotBeFrozen() {
+	if cache.frozen.Load() {
+		panic(ErrFrozen)
+	}
+}
+
+// Validate checks the config using the same rules as New and NewWithError,
+// returning a ConfigErrors describing every problem, or nil if it is valid.
+func (config Config) Validate() error {
+	var errs ConfigErrors
+
+	if config.Capacity < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.Capacity"))
+	}
+
+	if config.MaxAge < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.MaxAge"))
+	}
+
+	if config.MinAge < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.MinAge"))
+	}
+
+	if config.MinAge > 0 && config.MinAge > config.MaxAge {
+		errs = append(errs, errors.New("config.MinAge must be less than or equal to config.MaxAge"))
+	}
+
+	if config.AutoRefreshInterval < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.AutoRefreshInterval"))
+	}
+
+	if config.StatsWindow < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.StatsWindow"))
+	}
+
+	if config.RefreshInterval < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.RefreshInterval"))
+	}
+
+	if config.MaxCost < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.MaxCost"))
+	}
+
+	if config.MaxCost > 0 && config.CostFunc == nil {
+		errs = append(errs, errors.New("config.CostFunc is required with config.MaxCost"))
+	}
+
+	if config.MinCacheBytes < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.MinCacheBytes"))
+	}
+
+	if config.MaxCacheBytes < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.MaxCacheBytes"))
+	}
+
+	if config.MaxCacheBytes > 0 && config.MinCacheBytes > config.MaxCacheBytes {
+		errs = append(errs, errors.New("config.MinCacheBytes must be less than or equal to config.MaxCacheBytes"))
+	}
+
+	if (config.MinCacheBytes > 0 || config.MaxCacheBytes > 0) && config.SizeFunc == nil {
+		errs = append(errs, errors.New("config.SizeFunc is required with config.MinCacheBytes or config.MaxCacheBytes"))
+	}
+
+	if config.SampleSize < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.SampleSize"))
+	}
+
+	if config.EvictionPolicy == CostAwareEviction && (config.SampleSize == 0 || config.CostFunc == nil) {
+		errs = append(errs, errors.New("config.SampleSize and config.CostFunc are required with CostAwareEviction"))
+	}
+
+	if config.MaxEvictionsPerSecond < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.MaxEvictionsPerSecond"))
+	}
+
+	if config.MaxPerGroup < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.MaxPerGroup"))
+	}
+
+	if config.MaxPerGroup > 0 && config.GroupFunc == nil {
+		errs = append(errs, errors.New("config.GroupFunc is required with config.MaxPerGroup"))
+	}
+
+	if config.SoftMaxAge < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.SoftMaxAge"))
+	}
+
+	if config.MaxAge > 0 && config.SoftMaxAge > config.MaxAge {
+		errs = append(errs, errors.New("config.SoftMaxAge must be less than or equal to config.MaxAge"))
+	}
+
+	if config.MaxLifetime < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.MaxLifetime"))
+	}
+
+	if config.ExtendOnReadWithin < 0 {
+		errs = append(errs, errors.New("Must supply a zero or positive config.ExtendOnReadWithin"))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ConfigErrors lists the problems found when validating a Config.
+type ConfigErrors []error
+
+func (errs ConfigErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the individual problems.
+func (errs ConfigErrors) Unwrap() []error {
+	return errs
+}
+
+// configure applies a validated config to the cache fields it defines.
+func (cache *Cache) configure(config Config) {
+	minAge := config.MinAge
+	if minAge == 0 {
+		minAge = config.MaxAge
+	}
+
+	interval := config.ExpirationInterval
+	if interval <= 0 {
+		interval = config.MaxAge
+	}
+	if interval <= 0 {
+		interval = config.MaxLifetime
+	}
+
+	cache.name = config.Name
+	cache.keyFunc = config.KeyFunc
+	cache.shouldCache = config.ShouldCache
+	cache.sizeFunc = config.SizeFunc
+	cache.minCacheBytes = config.MinCacheBytes
+	cache.maxCacheBytes = config.MaxCacheBytes
+	cache.recorder = config.Recorder
+	cache.incrementPreservesTTL = config.IncrementPreservesTTL
+	cache.capacity = config.Capacity
+	cache.maxAge = config.MaxAge
+	cache.minAge = minAge
+	cache.extendOnReadWithin = config.ExtendOnReadWithin
+	cache.maxLifetime = config.MaxLifetime
+	cache.softMaxAge = config.SoftMaxAge
+	cache.deadlineFunc = config.DeadlineFunc
+	cache.expirationType = config.ExpirationType
+	cache.expirationInterval = interval
+	cache.onEviction = config.OnEviction
+	cache.onExpiration = config.OnExpiration
+	cache.onExpirationBatch = config.OnExpirationBatch
+	cache.onRemoval = config.OnRemoval
+	cache.events = config.Events
+	cache.costFunc = config.CostFunc
+	cache.maxCost = config.MaxCost
+	cache.reprice()
+	cache.sampleSize = config.SampleSize
+	cache.evictionPolicy = config.EvictionPolicy
+	cache.overflowPolicy = config.OverflowPolicy
+	cache.maxEvictionsPerSecond = config.MaxEvictionsPerSecond
+	cache.groupFunc = config.GroupFunc
+	cache.maxPerGroup = config.MaxPerGroup
+	cache.regroup()
+	cache.onFull = config.OnFull
+	cache.onNotFull = config.OnNotFull
+	cache.onChange = config.OnChange
+	cache.equal = config.Equal
+	cache.hasReapsExpired = config.HasReapsExpired
+	cache.overflow = config.Overflow
+	cache.tracer = config.Tracer
+	cache.strict = config.StrictMode
+	cache.statsDisabled = config.DisableStats
+	cache.trackLockHold = config.TrackLockHold
+	if config.StatsWindow != cache.statsWindow {
+		cache.statsWindow = config.StatsWindow
+		cache.window = nil
+		if config.StatsWindow > 0 {
+			cache.window = newStatsWindow(config.StatsWindow)
+			cache.observeWindow()
+		}
+	}
+}
+
+// startBackground starts the active expiration and refresh goroutines, if
+// enabled, until stopBackground is called. With ManualSweep, they are instead
+// kept for Tick to run.
+func (cache *Cache) startBackground(config Config) {
+	type job struct {
+		interval time.Duration
+		run      func()
+	}
+
+	var jobs []job
+	if cache.expirationType == ActiveExpiration && cache.expirationInterval > 0 {
+		jobs = append(jobs, job{cache.expirationInterval, func() {
+			cache.deleteExpired()
+		}})
+	}
+
+	if config.AutoRefreshInterval > 0 && config.Reloader != nil {
+		jobs = append(jobs, job{config.AutoRefreshInterval, func() {
+			cache.reloadAll(config.Reloader)
+		}})
+	}
+
+	if config.RefreshInterval > 0 && config.OnRefresh != nil {
+		jobs = append(jobs, job{config.RefreshInterval, func() {
+			items := config.OnRefresh()
+			// Only refresh the cache if the items provided is not nil
+			if items != nil {
+				cache.RefreshCache(items)
+			}
+		}})
+	}
+
+	if config.ManualSweep {
+		for _, j := range jobs {
+			cache.ticks = append(cache.ticks, j.run)
+		}
+		return
+	}
+
+	stop := make(chan struct{})
+	cache.stop = stop
+
+	for _, j := range jobs {
+		go func(j job) {
+			t := time.NewTicker(j.interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					j.run()
+				case <-stop:
+					return
+				}
+			}
+		}(j)
+	}
+}
+
+// Tick runs the active expiration sweep and the refreshes enabled by the
+// config once each, as their background goroutines would on every tick, when
+// ManualSweep is set. It is a no-op otherwise. The lock is not held while
+// calling OnRefresh or Reloader.
+func (cache *Cache) Tick() {
+	cache.mutex.RLock()
+	ticks := cache.ticks
+	cache.mutex.RUnlock()
+
+	for _, tick := range ticks {
+		tick()
+	}
+}
+
+// reloadAll reloads the value of every unexpired item with reload, invoked
+// outside the lock. An item set or removed while it was reloading is left as
+// is, as is one that failed to reload. A reloaded value rejected by
+// ShouldCache or the size limits removes the item, as Set would, and OnChange
+// is invoked for changed values once the lock is released.
+func (cache *Cache) reloadAll(reload func(key, value interface{}) (interface{}, error)) {
+	type snapshot struct {
+		key, value interface{}
+		version    uint64
+	}
+
+	cache.mutex.RLock()
+	snapshots := make([]snapshot, 0, cache.evictionList.Len())
+	for element := cache.evictionList.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*cacheEntry)
+		if !cache.expired(entry) {
+			snapshots = append(snapshots, snapshot{entry.key, entry.value, entry.version})
+		}
+	}
+	cache.mutex.RUnlock()
+
+	for _, s := range snapshots {
+		value, err := reload(s.key, s.value)
+		if err != nil {
+			continue
+		}
+
+		var notify func()
+		cache.lock()
+		if element, ok := cache.items[s.key]; ok && !cache.frozen.Load() {
+			entry := element.Value.(*cacheEntry)
+			if entry.version == s.version && !cache.expired(entry) && cache.admit(s.key, value) {
+				cache.record(opUpdate, s.key, value)
+				notify = cache.change(s.key, value)
+				cache.reload(entry, value)
+			}
+		}
+		cache.unlock()
+
+		if notify != nil {
+			notify()
+		}
+	}
+}
+
+// reload replaces the value of the entry and resets its timestamp as set
+// would, without changing how recently it was used. The caller must hold the
+// write lock.
+func (cache *Cache) reload(entry *cacheEntry, value interface{}) {
+	entry.value = value
+	entry.timestamp = cache.getTimestamp()
+	cache.price(entry)
+	cache.setDeadline(entry)
+	cache.version++
+	entry.version = cache.version
+	cache.evictOverCost()
+}
+
+// stopBackground stops the goroutines started by startBackground.
+func (cache *Cache) stopBackground() {
+	if cache.stop != nil {
+		close(cache.stop)
+		cache.stop = nil
+	}
+	cache.ticks = nil
+}
+
+// deleteExpired deletes all expired items, returning their number and total
+// cost. The lock is released between keys, and the sweep stops if the cache is
+// cleared or refreshed meanwhile, as its snapshot of the keys is then stale.
+func (cache *Cache) deleteExpired() (entries int, bytes int64) {
+	cache.mutex.RLock()
+	if cache.evictionList.Len() == 0 {
+		cache.mutex.RUnlock()
+		return 0, 0
+	}
+	keys := cache.keys()
+	clears := cache.clears
+	cache.mutex.RUnlock()
+
+	var batch []Entry
+	var onExpirationBatch func(entries []Entry)
+
+	for i := range keys {
+		cache.lock()
+		if cache.clears != clears {
+			cache.unlock()
+			break
+		}
+
+		onExpirationBatch = cache.onExpirationBatch
+		if element, ok := cache.items[keys[i]]; ok && !cache.frozen.Load() {
+			entry := element.Value.(*cacheEntry)
+			if cache.expired(entry) {
+				cache.deleteElement(element, ReasonExpired)
+				entries++
+				bytes += entry.cost
+				if onExpirationBatch != nil {
+					batch = append(batch, Entry{Key: entry.key, Value: entry.value})
+				} else if cache.onExpiration != nil {
+					cache.onExpiration(entry.key, entry.value)
+				}
+				freeEntry(entry)
+			}
+		}
+
+		cache.unlock()
+	}
+
+	if onExpirationBatch != nil && len(batch) > 0 {
+		onExpirationBatch(batch)
+	}
+	return entries, bytes
+}
+
+func (cache *Cache) evictOldest() bool {
+	_, ok := cache.evictOldestEntry(ReasonEvicted)
+	return ok
+}
+
+// evictToFit evicts the oldest items until the cache is within capacity.
+func (cache *Cache) evictToFit(reason RemoveReason) {
+	for cache.evictionList.Len() > cache.capacity {
+		cache.evictOldestEntry(reason)
+	}
+}
+
+// evictOldestEntry evicts the oldest item, returning it and whether the cache
+// was non-empty.
+func (cache *Cache) evictOldestEntry(reason RemoveReason) (Entry, bool) {
+	element := cache.evictionList.Back()
+	if element == nil {
+		return Entry{}, false
+	}
+
+	switch {
+	case cache.sampleSize > 0 && cache.evictionPolicy == CostAwareEviction:
+		element = cache.sampleCostliest()
+	case cache.sampleSize > 0:
+		element = cache.sampleOldest()
+	}
+	return cache.evictElement(element, reason), true
+}
+
+// sampleOldest returns the least recently accessed of up to sampleSize items,
+// relying on the randomized map iteration order to sample them. The newest
+// item, just set when evicting to make room, is only sampled if it is the only
+// one.
+func (cache *Cache) sampleOldest() *list.Element {
+	var oldest *list.Element
+	sampled := 0
+
+	newest := cache.evictionList.Front()
+	for _, element := range cache.items {
+		if element == newest && len(cache.items) > 1 {
+			continue
+		}
+		if oldest == nil || element.Value.(*cacheEntry).lastAccess.Load() < oldest.Value.(*cacheEntry).lastAccess.Load() {
+			oldest = element
+		}
+
+		sampled++
+		if sampled == cache.sampleSize {
+			break
+		}
+	}
+
+	return oldest
+}
+
+// sampleCostliest returns the item with the greatest cost multiplied by the
+// time since it was last accessed, of up to sampleSize items, relying on the
+// randomized map iteration order to sample them. As for sampleOldest, the
+// newest item is only sampled if it is the only one.
+func (cache *Cache) sampleCostliest() *list.Element {
+	var costliest *list.Element
+	var maxScore float64
+	sampled := 0
+
+	now := time.Now()
+	newest := cache.evictionList.Front()
+	for _, element := range cache.items {
+		if element == newest && len(cache.items) > 1 {
+			continue
+		}
+		entry := element.Value.(*cacheEntry)
+		score := float64(entry.cost) * float64(now.Sub(entry.accessedAt()))
+		if costliest == nil || score > maxScore {
+			costliest, maxScore = element, score
+		}
+
+		sampled++
+		if sampled == cache.sampleSize {
+			break
+		}
+	}
+
+	return costliest
+}
+
+// touch records an access to the item, moving it to the front of the
+// eviction list unless eviction is sampled.
+func (cache *Cache) touch(element *list.Element) {
+	element.Value.(*cacheEntry).lastAccess.Store(time.Now().UnixNano())
+	if cache.sampleSize == 0 {
+		cache.evictionList.MoveToFront(element)
+	}
+}
+
+// evictOldestInGroup evicts the oldest item in the group, returning it and
+// whether the group was non-empty.
+func (cache *Cache) evictOldestInGroup(group string) (Entry, bool) {
+	for element := cache.evictionList.Back(); element != nil; element = element.Prev() {
+		if element.Value.(*cacheEntry).group == group {
+			return cache.evictElement(element, ReasonEvicted), true
+		}
+	}
+
+	return Entry{}, false
 }
 
-// OnEviction sets the eviction callback.
-func (cache *Cache) OnEviction(callback func(key, value interface{})) {
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+// evictGroupsToFit evicts the oldest items of every group over MaxPerGroup
+// until the group is within it.
+func (cache *Cache) evictGroupsToFit() {
+	if cache.maxPerGroup == 0 {
+		return
+	}
 
-	cache.onEviction = callback
+	for group := range cache.groups {
+		for cache.groups[group] > cache.maxPerGroup {
+			if _, ok := cache.evictOldestInGroup(group); !ok {
+				break
+			}
+		}
+	}
 }
 
-// OnExpiration sets the expiration callback.
-func (cache *Cache) OnExpiration(callback func(key, value interface{})) {
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
+// evictElement evicts the item due to the LRU policy, or a resize when reason
+// is ReasonResized, spilling it over into the overflow cache or invoking the
+// OnEviction callback. The entry is returned to the pool, so only a copy of
+// the item is returned.
+func (cache *Cache) evictElement(element *list.Element, reason RemoveReason) Entry {
+	cache.count(&cache.evictions)
+	if cache.maxEvictionsPerSecond > 0 {
+		cache.evictionsThisSecond = cache.evictionsInSecond() + 1
+	}
+	entry := cache.deleteElement(element, reason)
+	if reason == ReasonEvicted {
+		cache.observeEvictionAge(time.Since(entry.timestamp))
+	}
+	if cache.overflow != nil {
+		cache.overflow.spill(entry, cache.maxAge)
+	} else if cache.onEviction != nil {
+		cache.onEviction(entry.key, entry.value)
+	}
+	evicted := Entry{Key: entry.key, Value: entry.value}
+	freeEntry(entry)
+	return evicted
+}
 
-	cache.onExpiration = callback
+// evictionThrottled returns whether MaxEvictionsPerSecond evictions already
+// occurred in the current second.
+func (cache *Cache) evictionThrottled() bool {
+	return cache.maxEvictionsPerSecond > 0 && cache.evictionsInSecond() >= cache.maxEvictionsPerSecond
 }
 
-// Stats returns cache stats.
-func (cache *Cache) Stats() Stats {
-	cache.mutex.RLock()
-	defer cache.mutex.RUnlock()
+// evictionsInSecond returns the number of evictions in the current second,
+// starting a new one as needed.
+func (cache *Cache) evictionsInSecond() int {
+	if second := time.Now().Unix(); second != cache.evictionSecond {
+		cache.evictionSecond = second
+		cache.evictionsThisSecond = 0
+	}
+	return cache.evictionsThisSecond
+}
 
-	return Stats{
-		Capacity:  int64(cache.capacity),
-		Count:     int64(cache.evictionList.Len()),
-		Sets:      cache.sets,
-		Gets:      cache.gets,
-		Hits:      cache.hits,
-		Misses:    cache.misses,
-		Evictions: cache.evictions,
+// refuses returns the stats counter of the reason insert would not store a new
+// item at key, i.e. RejectNewOverflow or MaxEvictionsPerSecond, or nil if it
+// would store it.
+func (cache *Cache) refuses(key, value interface{}) *int64 {
+	if cache.overflowPolicy == RejectNewOverflow && cache.evictionList.Len() >= cache.capacity {
+		return &cache.rejections
+	}
+	if cache.evictionDisabled == 0 && cache.evictionThrottled() && cache.wouldEvict(key, value) {
+		return &cache.throttled
 	}
+	return nil
 }
 
-// Resize the cache to hold at most n entries. If n is smaller than the current
-// size, entries are evicted to fit the new size. It errors if n <= 0.
-func (cache *Cache) Resize(n int) error {
-	if n <= 0 {
-		return errors.New("must supply a positive capacity to Resize")
+// wouldEvict returns whether storing a new item at key would evict another,
+// due to the capacity, MaxPerGroup or MaxCost.
+func (cache *Cache) wouldEvict(key, value interface{}) bool {
+	if cache.evictionList.Len() >= cache.capacity {
+		return true
+	}
+	if cache.groupFunc != nil && cache.maxPerGroup > 0 && cache.groups[cache.groupFunc(key)] >= cache.maxPerGroup {
+		return true
 	}
+	return cache.maxCost > 0 && cache.cost+cache.costFunc(key, value) > cache.maxCost
+}
 
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
-	c := cache.capacity
-	cache.capacity = n
+// accepts returns whether a new item at key would be stored by Set. The caller
+// must hold the write lock.
+func (cache *Cache) accepts(key, value interface{}) bool {
+	return cache.capacity > 0 && cache.refuses(key, value) == nil && cache.admit(key, value)
+}
 
-	for i := 0; i < c-n; i++ {
-		successful := cache.evictOldest()
-		if !successful {
-			break
+// set stores the key:value pair with the given timestamp, evicting the oldest
+// entry if the cache is over capacity. Returns the evicted item and whether
+// one was evicted. A disabled cache stores nothing. The caller must hold the
+// write lock.
+func (cache *Cache) set(key, value interface{}, timestamp time.Time) (Entry, bool) {
+	return cache.insert(key, value, timestamp, time.Time{}, false)
+}
+
+// insert implements set, ignoring the overflow policy if force is set. A
+// non-zero createdAt is kept as the item's creation time, for items moved from
+// another cache, instead of the time of the insert.
+func (cache *Cache) insert(key, value interface{}, timestamp, createdAt time.Time, force bool) (Entry, bool) {
+	if cache.capacity == 0 {
+		return Entry{}, false
+	}
+
+	cache.record(opSet, key, value)
+
+	if element, ok := cache.items[key]; ok && cache.expired(element.Value.(*cacheEntry)) {
+		// The expired item is replaced by a new one, rather than overwritten
+		// with its creation time, which MaxLifetime is measured from
+		entry := cache.deleteElement(element, ReasonExpired)
+		if cache.onExpiration != nil {
+			cache.onExpiration(entry.key, entry.value)
 		}
+		freeEntry(entry)
 	}
 
-	return nil
+	if element, ok := cache.items[key]; ok {
+		cache.wake(key, value)
+		cache.touch(element)
+		entry := element.Value.(*cacheEntry)
+		entry.value = value
+		entry.timestamp = timestamp
+		entry.noExtend = false
+		if !createdAt.IsZero() {
+			entry.createdAt = createdAt
+		}
+		cache.undepend(entry)
+		cache.price(entry)
+		cache.setDeadline(entry)
+		cache.version++
+		entry.version = cache.version
+		return cache.evictOverCost()
+	}
+
+	if !force {
+		if counter := cache.refuses(key, value); counter != nil {
+			cache.count(counter)
+			return Entry{}, false
+		}
+	}
+
+	cache.wake(key, value)
+
+	var victim Entry
+	var evicted bool
+	entry := newEntry(key, value, timestamp)
+	if !createdAt.IsZero() {
+		entry.createdAt = createdAt
+	}
+	cache.price(entry)
+	cache.setDeadline(entry)
+	cache.version++
+	entry.version = cache.version
+	if cache.groupFunc != nil {
+		entry.group = cache.groupFunc(key)
+		if cache.maxPerGroup > 0 && cache.groups[entry.group] >= cache.maxPerGroup && cache.evictionDisabled == 0 {
+			victim, evicted = cache.evictOldestInGroup(entry.group)
+		}
+		cache.groups[entry.group]++
+	}
+
+	element := cache.evictionList.PushFront(entry)
+	cache.items[key] = element
+
+	if cache.evictionList.Len() > cache.capacity && cache.evictionDisabled == 0 {
+		victim, evicted = cache.evictOldestEntry(ReasonEvicted)
+	}
+	if entry, ok := cache.evictOverCost(); ok {
+		victim, evicted = entry, true
+	}
+	cache.checkFull()
+	return victim, evicted
 }
 
-func (cache *Cache) deleteExpired() {
-	keys := cache.Keys()
+// increment returns the integer value plus delta, with the type of the value
+// and as an int64, and whether the value is an integer at all.
+func increment(value interface{}, delta int64) (interface{}, int64, bool) {
+	if n, ok := value.(int64); ok {
+		return n + delta, n + delta, true
+	}
 
-	for i := range keys {
-		cache.mutex.Lock()
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		result := reflect.New(v.Type()).Elem()
+		result.SetInt(v.Int() + delta)
+		return result.Interface(), result.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		result := reflect.New(v.Type()).Elem()
+		result.SetUint(v.Uint() + uint64(delta))
+		return result.Interface(), int64(result.Uint()), true
+	}
+	return nil, 0, false
+}
 
-		if element, ok := cache.items[keys[i]]; ok {
-			entry := element.Value.(*cacheEntry)
-			if cache.maxAge > 0 && time.Since(entry.timestamp) > cache.maxAge {
-				cache.deleteElement(element)
-				if cache.onExpiration != nil {
-					cache.onExpiration(entry.key, entry.value)
-				}
-			}
+// setFunc implements the Set variants. Under the write lock, it calls compute
+// with the canonical key for the value to set, which may report false to leave
+// the cache as is. The pair is then, if admitted, counted and passed to store,
+// with OnChange invoked once the lock is released. A rejected pair removes any
+// item at the key. Returns whether store was called.
+func (cache *Cache) setFunc(key interface{}, compute func(key interface{}) (interface{}, bool), store func(key, value interface{})) bool {
+	var notify func()
+	defer func() {
+		if notify != nil {
+			notify()
 		}
+	}()
+
+	cache.lock()
+	defer cache.unlock()
+
+	cache.mustNotBeFrozen()
 
-		cache.mutex.Unlock()
+	key = cache.canonical(key)
+	value, ok := compute(key)
+	if !ok {
+		return false
+	}
+
+	if !cache.admit(key, value) {
+		return false
 	}
+
+	cache.countSet(key)
+	notify = cache.change(key, value)
+	store(key, value)
+	return true
 }
 
-func (cache *Cache) evictOldest() bool {
-	element := cache.evictionList.Back()
-	if element == nil {
+// setValue calls setFunc to set key to value.
+func (cache *Cache) setValue(key, value interface{}, store func(key, value interface{})) bool {
+	return cache.setFunc(key, func(interface{}) (interface{}, bool) {
+		return value, true
+	}, store)
+}
+
+// change returns a function invoking the OnChange callback if setting key to
+// value would change an unexpired item, or nil otherwise. The caller must hold
+// the write lock, and invoke the function after releasing it.
+func (cache *Cache) change(key, value interface{}) func() {
+	onChange := cache.onChange
+	if onChange == nil {
+		return nil
+	}
+
+	element, ok := cache.items[key]
+	if !ok || cache.expired(element.Value.(*cacheEntry)) {
+		return nil
+	}
+
+	old := element.Value.(*cacheEntry).value
+	if cache.unchanged(old, value) {
+		return nil
+	}
+	return func() {
+		onChange(key, old, value)
+	}
+}
+
+// unchanged reports whether old and new are equal, using the configured Equal
+// function or ==. Values of incomparable types are never equal under ==.
+func (cache *Cache) unchanged(old, new interface{}) bool {
+	if cache.equal != nil {
+		return cache.equal(old, new)
+	}
+	if old != nil && !reflect.TypeOf(old).Comparable() {
 		return false
 	}
+	if new != nil && !reflect.TypeOf(new).Comparable() {
+		return false
+	}
+	return old == new
+}
 
-	cache.evictions++
-	entry := cache.deleteElement(element)
-	if cache.onEviction != nil {
-		cache.onEviction(entry.key, entry.value)
+// record logs the operation to the configured Recorder, if any.
+func (cache *Cache) record(op string, key, value interface{}) {
+	if cache.recorder != nil {
+		cache.recorder.record(op, key, value)
+	}
+}
+
+// admit returns whether the key:value pair may be stored according to the
+// configured ShouldCache function and size band, removing any item stored at
+// key otherwise. The caller must hold the write lock.
+func (cache *Cache) admit(key, value interface{}) bool {
+	if cache.admissible(key, value) {
+		return true
+	}
+
+	if element, ok := cache.items[key]; ok {
+		freeEntry(cache.deleteElement(element, ReasonRemoved))
+	}
+	return false
+}
+
+// admissible returns whether the key:value pair may be stored according to the
+// configured ShouldCache function and size band, as admit does, without
+// removing anything.
+func (cache *Cache) admissible(key, value interface{}) bool {
+	return cache.fitsSize(key, value) && (cache.shouldCache == nil || cache.shouldCache(key, value))
+}
+
+// fitsSize returns whether the size of the value is within MinCacheBytes and
+// MaxCacheBytes, counting it as skipped otherwise.
+func (cache *Cache) fitsSize(key, value interface{}) bool {
+	if cache.minCacheBytes == 0 && cache.maxCacheBytes == 0 {
+		return true
+	}
+
+	size := cache.sizeFunc(key, value)
+	if size < cache.minCacheBytes || (cache.maxCacheBytes > 0 && size > cache.maxCacheBytes) {
+		cache.count(&cache.skippedBySize)
+		return false
 	}
 	return true
 }
 
-func (cache *Cache) deleteElement(element *list.Element) *cacheEntry {
+// wake hands the value to the goroutines blocked in WaitFor on key. The caller
+// must hold the write lock.
+func (cache *Cache) wake(key, value interface{}) {
+	for _, waiter := range cache.waiters[key] {
+		waiter <- value
+	}
+	delete(cache.waiters, key)
+}
+
+// canonical returns the key transformed by the configured KeyFunc, if any.
+func (cache *Cache) canonical(key interface{}) interface{} {
+	if cache.keyFunc == nil {
+		return key
+	}
+	return cache.keyFunc(key)
+}
+
+// setDeadline updates the deadline of the entry using the configured
+// DeadlineFunc.
+func (cache *Cache) setDeadline(entry *cacheEntry) {
+	if cache.deadlineFunc != nil {
+		entry.deadline = cache.deadlineFunc(entry.key, entry.value)
+	}
+}
+
+// price updates the cost of the entry, and the total cost of the cache, using
+// the configured CostFunc.
+func (cache *Cache) price(entry *cacheEntry) {
+	if cache.costFunc == nil {
+		return
+	}
+
+	cost := cache.costFunc(entry.key, entry.value)
+	cache.cost += cost - entry.cost
+	entry.cost = cost
+}
+
+// evictOverCost evicts the oldest items until the total cost is within the
+// configured MaxCost, returning the last evicted item and whether any was.
+func (cache *Cache) evictOverCost() (Entry, bool) {
+	var victim Entry
+	var evicted bool
+	for cache.maxCost > 0 && cache.cost > cache.maxCost && cache.evictionDisabled == 0 {
+		entry, ok := cache.evictOldestEntry(ReasonEvicted)
+		if !ok {
+			break
+		}
+		victim, evicted = entry, true
+	}
+	return victim, evicted
+}
+
+// reprice recomputes the cost of every item with the configured CostFunc.
+func (cache *Cache) reprice() {
+	cache.cost = 0
+	for _, element := range cache.items {
+		entry := element.Value.(*cacheEntry)
+		entry.cost = 0
+		cache.price(entry)
+	}
+}
+
+// spill stores an entry evicted from a cache whose max age was maxAge,
+// preserving its remaining time to live and its creation time.
+func (cache *Cache) spill(entry *cacheEntry, maxAge time.Duration) {
+	cache.lock()
+	defer cache.unlock()
+
+	if cache.frozen.Load() {
+		return
+	}
+
+	cache.countSet(entry.key)
+	cache.insert(entry.key, entry.value, cache.rebase(entry.timestamp, maxAge), entry.createdAt, false)
+}
+
+// reachableFrom returns whether the chain of overflow caches starting at
+// overflow leads to the cache.
+func (cache *Cache) reachableFrom(overflow *Cache) bool {
+	visited := make(map[*Cache]bool)
+	for next := overflow; next != nil && !visited[next]; {
+		if next == cache {
+			return true
+		}
+		visited[next] = true
+
+		next.mutex.RLock()
+		following := next.overflow
+		next.mutex.RUnlock()
+		next = following
+	}
+	return false
+}
+
+// take returns the live value at key as a Get would, without checking the
+// cache's own overflow. If the cache `into` would store the item, it is also
+// removed and returned, with its timestamp rebased for into, for the caller to
+// set once this cache's lock is released. Otherwise, the item is left in place.
+// An item past the MaxLifetime of into is expired instead of served. The caller
+// must hold the write lock of into.
+func (cache *Cache) take(key interface{}, into *Cache) (interface{}, *cacheEntry, bool) {
+	cache.lock()
+	defer cache.unlock()
+
+	if cache.frozen.Load() {
+		return nil, nil, false
+	}
+
+	cache.count(&cache.gets)
+
+	element, ok := cache.items[key]
+	if !ok {
+		cache.count(&cache.misses)
+		return nil, nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if cache.expired(entry) || into.outlived(entry) {
+		cache.deleteElement(element, ReasonExpired)
+		cache.count(&cache.misses)
+		if cache.onExpiration != nil {
+			cache.onExpiration(entry.key, entry.value)
+		}
+		freeEntry(entry)
+		return nil, nil, false
+	}
+
+	cache.count(&cache.hits)
+	if !into.accepts(key, entry.value) {
+		cache.touch(element)
+		return entry.value, nil, true
+	}
+
+	cache.detachElement(element, ReasonRemoved)
+	entry.timestamp = into.rebase(entry.timestamp, cache.maxAge)
+	return entry.value, entry, true
+}
+
+// rebase converts a timestamp taken by a cache whose max age was maxAge into
+// one that expires at the same time under this cache's max age. If either
+// cache does not expire items, a fresh timestamp is used.
+func (cache *Cache) rebase(timestamp time.Time, maxAge time.Duration) time.Time {
+	if maxAge == 0 || cache.maxAge == 0 {
+		return cache.getTimestamp()
+	}
+	return timestamp.Add(maxAge - cache.maxAge)
+}
+
+// deleteElement removes the element from the cache as detachElement does, also
+// removing the items depending on it.
+func (cache *Cache) deleteElement(element *list.Element, reason RemoveReason) *cacheEntry {
+	entry := cache.detachElement(element, reason)
+	cache.removeDependents(entry.key)
+	return entry
+}
+
+// detachElement removes the element from the cache, invoking the removal
+// callbacks, and returns its entry. Items depending on it are left in place,
+// for removals that do not invalidate the item, e.g. moving it to another
+// cache.
+func (cache *Cache) detachElement(element *list.Element, reason RemoveReason) *cacheEntry {
+	entry := cache.unlink(element)
+	cache.undepend(entry)
+	cache.notifyRemoved(entry, reason)
+	cache.checkFull()
+	return entry
+}
+
+// unlink removes the element from the cache without notifying anyone, and
+// returns its entry.
+func (cache *Cache) unlink(element *list.Element) *cacheEntry {
 	cache.evictionList.Remove(element)
 	entry := element.Value.(*cacheEntry)
 	delete(cache.items, entry.key)
+	cache.cost -= entry.cost
+	if cache.groupFunc != nil {
+		cache.ungroup(entry.group)
+	}
 	return entry
 }
 
+// notifyRemoved invokes the removal callbacks and sends an Event for the
+// removed entry.
+func (cache *Cache) notifyRemoved(entry *cacheEntry, reason RemoveReason) {
+	cache.record(opRemove, entry.key, nil)
+	cache.notifyKeyRemoved(entry, reason)
+	if cache.onRemoval != nil {
+		cache.onRemoval(entry.key, entry.value, reason)
+	}
+	if cache.events != nil {
+		cache.sendEvent(entry, reason)
+	}
+}
+
+// depend records that the entry depends on the items at the base keys, so that
+// removing any of them removes it.
+func (cache *Cache) depend(entry *cacheEntry, bases []interface{}) {
+	if len(bases) == 0 {
+		return
+	}
+
+	if cache.dependents == nil {
+		cache.dependents = make(map[interface{}]map[interface{}]struct{})
+	}
+	for _, base := range bases {
+		if cache.dependents[base] == nil {
+			cache.dependents[base] = make(map[interface{}]struct{})
+		}
+		cache.dependents[base][entry.key] = struct{}{}
+	}
+	entry.dependsOn = bases
+}
+
+// undepend clears the dependencies of the entry.
+func (cache *Cache) undepend(entry *cacheEntry) {
+	for _, base := range entry.dependsOn {
+		delete(cache.dependents[base], entry.key)
+		if len(cache.dependents[base]) == 0 {
+			delete(cache.dependents, base)
+		}
+	}
+	entry.dependsOn = nil
+}
+
+// removeDependents removes the items depending on key, and transitively those
+// depending on them. The removed entries are not returned to the pool, as the
+// caller may still reference them, e.g. while iterating.
+func (cache *Cache) removeDependents(key interface{}) {
+	dependents, ok := cache.dependents[key]
+	if !ok {
+		return
+	}
+
+	// Removing the key from the index first stops cycles from recursing back
+	delete(cache.dependents, key)
+	for dependent := range dependents {
+		if element, ok := cache.items[dependent]; ok {
+			cache.deleteElement(element, ReasonRemoved)
+		}
+	}
+}
+
+// sendEvent sends an Event for the removed entry to the configured channel,
+// unless it would block.
+func (cache *Cache) sendEvent(entry *cacheEntry, reason RemoveReason) {
+	select {
+	case cache.events <- Event{
+		Cache:      cache.name,
+		Key:        entry.key,
+		Value:      entry.value,
+		Reason:     reason,
+		Age:        time.Since(entry.timestamp),
+		LastAccess: entry.accessedAt(),
+	}:
+	default:
+	}
+}
+
+// ungroup decrements the number of items in the group.
+func (cache *Cache) ungroup(group string) {
+	if cache.groups[group] <= 1 {
+		delete(cache.groups, group)
+	} else {
+		cache.groups[group]--
+	}
+}
+
+// regroup recomputes the group of every item with the configured GroupFunc.
+func (cache *Cache) regroup() {
+	cache.groups = make(map[string]int)
+	if cache.groupFunc == nil {
+		return
+	}
+
+	for key, element := range cache.items {
+		entry := element.Value.(*cacheEntry)
+		entry.group = cache.groupFunc(key)
+		cache.groups[entry.group]++
+	}
+}
+
+// checkFull invokes the OnFull or OnNotFull callback when the number of items
+// crosses the capacity boundary.
+func (cache *Cache) checkFull() {
+	full := cache.evictionList.Len() >= cache.capacity
+	if full == cache.full {
+		return
+	}
+
+	cache.full = full
+	if full && cache.onFull != nil {
+		cache.onFull()
+	} else if !full && cache.onNotFull != nil {
+		cache.onNotFull()
+	}
+}
+
+// notifyKeyRemoved invokes the callbacks registered for the entry's key,
+// dropping those that were not registered as persistent.
+func (cache *Cache) notifyKeyRemoved(entry *cacheEntry, reason RemoveReason) {
+	callbacks, ok := cache.keyCallbacks[entry.key]
+	if !ok {
+		return
+	}
+
+	remaining := callbacks[:0]
+	for _, callback := range callbacks {
+		callback.fn(entry.value, reason)
+		if callback.persistent {
+			remaining = append(remaining, callback)
+		}
+	}
+
+	if len(remaining) == 0 {
+		delete(cache.keyCallbacks, entry.key)
+	} else {
+		cache.keyCallbacks[entry.key] = remaining
+	}
+}
+
+// countSet counts a set of the key, as an insert or an overwrite depending on
+// whether or not an unexpired item is at the key.
+func (cache *Cache) countSet(key interface{}) {
+	if cache.statsDisabled {
+		return
+	}
+
+	cache.observeWindow()
+	cache.sets++
+	if element, ok := cache.items[key]; ok && !cache.expired(element.Value.(*cacheEntry)) {
+		cache.overwrites++
+	} else {
+		cache.inserts++
+	}
+}
+
+// count increments the given statistics counter unless stats are disabled.
+// Counters are incremented atomically, as getShared counts gets and hits under
+// the read lock.
+func (cache *Cache) count(counter *int64) {
+	if !cache.statsDisabled {
+		cache.observeWindow()
+		atomic.AddInt64(counter, 1)
+	}
+}
+
+// observeEvictionAge records the age of an item evicted due to the LRU policy.
+func (cache *Cache) observeEvictionAge(age time.Duration) {
+	if cache.statsDisabled {
+		return
+	}
+
+	if cache.lruEvictions == 0 || age < cache.evictionAgeMin {
+		cache.evictionAgeMin = age
+	}
+	if age > cache.evictionAgeMax {
+		cache.evictionAgeMax = age
+	}
+	cache.lruEvictions++
+	cache.evictionAgeTotal += age
+}
+
+// extendOnRead resets the timestamp of an entry read within the configured
+// window of its expiry.
+func (cache *Cache) extendOnRead(entry *cacheEntry) {
+	if cache.extendOnReadWithin == 0 || cache.maxAge == 0 || entry.noExtend {
+		return
+	}
+
+	if time.Until(entry.timestamp.Add(cache.maxAge)) <= cache.extendOnReadWithin {
+		entry.timestamp = cache.getTimestamp()
+	}
+}
+
+func (cache *Cache) expired(entry *cacheEntry) bool {
+	if !entry.deadline.IsZero() && time.Now().After(entry.deadline) {
+		return true
+	}
+	if cache.outlived(entry) {
+		return true
+	}
+	return cache.maxAge > 0 && time.Since(entry.timestamp) > cache.maxAge
+}
+
+// outlived returns whether the entry was created longer than MaxLifetime ago.
+func (cache *Cache) outlived(entry *cacheEntry) bool {
+	return cache.maxLifetime > 0 && time.Since(entry.createdAt) > cache.maxLifetime
+}
+
 func (cache *Cache) getTimestamp() time.Time {
 	timestamp := time.Now()
 	if cache.minAge == cache.maxAge {
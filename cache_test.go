@@ -1,7 +1,13 @@
 package agecache
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -10,10 +16,32 @@ import (
 
 func TestInvalidCapacity(t *testing.T) {
 	assert.Panics(t, func() {
-		New(Config{Capacity: 0})
+		New(Config{Capacity: -1})
 	})
 }
 
+func TestDisabled(t *testing.T) {
+	cache := New(Config{Capacity: 0})
+
+	evict := cache.Set("foo", 1)
+	assert.False(t, evict)
+
+	_, ok := cache.Get("foo")
+	assert.False(t, ok)
+	assert.False(t, cache.Has("foo"))
+	assert.False(t, cache.EvictOldest())
+	assert.Equal(t, 0, cache.Len())
+
+	cache.RefreshCache(map[interface{}]interface{}{"foo": 1})
+	assert.Equal(t, 0, cache.Len())
+
+	stats := cache.Stats()
+	assert.Equal(t, int64(2), stats.Sets)
+	assert.Equal(t, int64(1), stats.Gets)
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.Equal(t, int64(0), stats.Evictions)
+}
+
 func TestInvalidMaxAge(t *testing.T) {
 	assert.Panics(t, func() {
 		New(Config{Capacity: 1, MaxAge: -1 * time.Hour})
@@ -34,85 +62,1173 @@ func TestInvalidMinAge(t *testing.T) {
 	})
 }
 
-func TestInvalidRefreshInterval(t *testing.T) {
-	assert.Panics(t, func() {
-		New(Config{Capacity: 1, RefreshInterval: -1 * time.Hour})
+func TestInvalidRefreshInterval(t *testing.T) {
+	assert.Panics(t, func() {
+		New(Config{Capacity: 1, RefreshInterval: -1 * time.Hour})
+	})
+}
+
+func TestNewWithError(t *testing.T) {
+	cache, err := NewWithError(Config{Capacity: -1})
+	assert.Nil(t, cache)
+	assert.Error(t, err)
+
+	cache, err = NewWithError(Config{Capacity: 1})
+	assert.NotNil(t, cache)
+	assert.NoError(t, err)
+
+	assert.Panics(t, func() {
+		NewWithError(Config{Capacity: -1, StrictMode: true})
+	})
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Config{Capacity: 1, MaxAge: time.Hour}.Validate())
+
+	err := Config{Capacity: -1, MaxAge: time.Minute, MinAge: time.Hour}.Validate()
+	assert.EqualError(t, err, "Must supply a zero or positive config.Capacity; "+
+		"config.MinAge must be less than or equal to config.MaxAge")
+
+	var errs ConfigErrors
+	assert.ErrorAs(t, err, &errs)
+	assert.Len(t, errs, 2)
+}
+
+func TestStrictMode(t *testing.T) {
+	cache := New(Config{Capacity: 1, MaxAge: time.Hour, StrictMode: true})
+
+	assert.Panics(t, func() {
+		cache.SetMaxAge(-1 * time.Hour)
+	})
+	assert.Panics(t, func() {
+		cache.SetMinAge(2 * time.Hour)
+	})
+	assert.Panics(t, func() {
+		cache.Resize(0)
+	})
+	assert.Panics(t, func() {
+		cache.Reconfigure(Config{Capacity: -1})
+	})
+	assert.NoError(t, cache.SetMaxAge(2*time.Hour))
+}
+
+func TestBasicSetGet(t *testing.T) {
+	cache := New(Config{Capacity: 2})
+	cache.Set("foo", 1)
+	cache.Set("bar", 2)
+
+	val, ok := cache.Get("foo")
+	assert.True(t, ok)
+	assert.Equal(t, 1, val)
+
+	val, ok = cache.Get("bar")
+	assert.True(t, ok)
+	assert.Equal(t, 2, val)
+}
+
+func TestBasicSetOverwrite(t *testing.T) {
+	cache := New(Config{Capacity: 2})
+	cache.Set("foo", 1)
+	evict := cache.Set("foo", 2)
+	val, ok := cache.Get("foo")
+
+	assert.False(t, evict)
+	assert.True(t, ok)
+	assert.Equal(t, 2, val)
+}
+
+func TestEviction(t *testing.T) {
+	var k, v interface{}
+
+	cache := New(Config{
+		Capacity: 2,
+		OnEviction: func(key, value interface{}) {
+			k = key
+			v = value
+		},
+	})
+
+	cache.Set("foo", 1)
+	cache.Set("bar", 2)
+	evict := cache.Set("baz", 3)
+	val, ok := cache.Get("foo")
+
+	assert.True(t, evict)
+	assert.False(t, ok)
+	assert.Nil(t, val)
+	assert.Equal(t, "foo", k)
+	assert.Equal(t, 1, v)
+}
+
+func TestRejectNewOverflow(t *testing.T) {
+	cache := New(Config{Capacity: 2, OverflowPolicy: RejectNewOverflow})
+	assert.True(t, cache.TrySet("foo", 1))
+	assert.True(t, cache.TrySet("bar", 2))
+
+	assert.False(t, cache.TrySet("baz", 3))
+	assert.False(t, cache.Set("baz", 3))
+	assert.True(t, cache.TrySet("foo", 4))
+
+	assert.ElementsMatch(t, []interface{}{"foo", "bar"}, cache.Keys())
+	assert.Equal(t, int64(2), cache.Stats().Rejections)
+}
+
+func TestMaxEvictionsPerSecond(t *testing.T) {
+	var evicted []interface{}
+
+	cache := New(Config{
+		Capacity:              1,
+		MaxEvictionsPerSecond: 2,
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	for i := 0; i < 10; i++ {
+		cache.Set(i, i)
+	}
+
+	// The sets may straddle two seconds
+	stats := cache.Stats()
+	assert.True(t, len(evicted) <= 4, "evicted %v", evicted)
+	assert.Equal(t, int64(len(evicted)), stats.Evictions)
+	assert.Equal(t, int64(9-len(evicted)), stats.Throttled)
+
+	// Overwrites do not evict, so are not throttled
+	key := cache.Keys()[0]
+	cache.Set(key, -1)
+	value, _ := cache.Peek(key)
+	assert.Equal(t, -1, value)
+
+	cache.evictionSecond--
+	assert.True(t, cache.Set("next", 1))
+	assert.True(t, cache.ForceSet("forced", 2))
+}
+
+func TestMaxEvictionsPerSecondCost(t *testing.T) {
+	cache := New(Config{
+		Capacity:              100,
+		MaxCost:               1,
+		CostFunc:              func(key, value interface{}) int64 { return 1 },
+		MaxEvictionsPerSecond: 1,
+	})
+	for i := 0; i < 10; i++ {
+		cache.Set(i, i)
+	}
+
+	// The sets may straddle two seconds
+	stats := cache.Stats()
+	assert.True(t, stats.Evictions <= 2, "evicted %d", stats.Evictions)
+	assert.Equal(t, 9-stats.Evictions, stats.Throttled)
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestMaxEvictionsPerSecondGroup(t *testing.T) {
+	cache := New(Config{
+		Capacity:              100,
+		GroupFunc:             func(key interface{}) string { return "group" },
+		MaxPerGroup:           1,
+		MaxEvictionsPerSecond: 1,
+	})
+	for i := 0; i < 10; i++ {
+		cache.Set(i, i)
+	}
+
+	stats := cache.Stats()
+	assert.True(t, stats.Evictions <= 2, "evicted %d", stats.Evictions)
+	assert.Equal(t, 9-stats.Evictions, stats.Throttled)
+}
+
+func TestForceSet(t *testing.T) {
+	var evicted []interface{}
+
+	cache := New(Config{
+		Capacity:       2,
+		OverflowPolicy: RejectNewOverflow,
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	cache.Set("foo", 1)
+	cache.Set("bar", 2)
+
+	assert.True(t, cache.ForceSet("baz", 3))
+	assert.Equal(t, []interface{}{"foo"}, evicted)
+	assert.Equal(t, []interface{}{"bar", "baz"}, cache.OrderedKeys())
+	assert.Equal(t, int64(0), cache.Stats().Rejections)
+}
+
+func TestSetAndReport(t *testing.T) {
+	cache := New(Config{Capacity: 2})
+	cache.Set("foo", 1)
+
+	_, _, evicted := cache.SetAndReport("bar", 2)
+	assert.False(t, evicted)
+
+	key, value, evicted := cache.SetAndReport("baz", 3)
+	assert.True(t, evicted)
+	assert.Equal(t, "foo", key)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, int64(3), cache.Stats().Sets)
+}
+
+func TestOnChange(t *testing.T) {
+	var changes [][]interface{}
+
+	cache := New(Config{
+		Capacity: 2,
+		OnChange: func(key, old, new interface{}) {
+			changes = append(changes, []interface{}{key, old, new})
+		},
+	})
+
+	cache.Set("foo", 1)
+	cache.Set("foo", 1)
+	assert.Empty(t, changes)
+
+	cache.Set("foo", 2)
+	cache.SetAndReport("foo", 3)
+	assert.Equal(t, [][]interface{}{{"foo", 1, 2}, {"foo", 2, 3}}, changes)
+
+	changes = nil
+	cache.TrySet("foo", 4)
+	cache.ForceSet("foo", 5)
+	cache.SetNoExtend("foo", 6)
+	cache.SetWithDeps("foo", 7)
+	assert.Equal(t, [][]interface{}{{"foo", 3, 4}, {"foo", 4, 5}, {"foo", 5, 6}, {"foo", 6, 7}}, changes)
+
+	changes = nil
+	cache.Set("bar", []int{1})
+	cache.Set("bar", []int{1})
+	assert.Equal(t, [][]interface{}{{"bar", []int{1}, []int{1}}}, changes)
+}
+
+func TestOnChangeEqual(t *testing.T) {
+	var changed bool
+	var cache *Cache
+
+	cache = New(Config{
+		Capacity: 1,
+		OnChange: func(key, old, new interface{}) {
+			// The lock is released before OnChange is invoked
+			changed = cache.Has(key)
+		},
+		Equal: func(old, new interface{}) bool {
+			return len(old.([]int)) == len(new.([]int))
+		},
+	})
+
+	cache.Set("foo", []int{1})
+	cache.Set("foo", []int{2})
+	assert.False(t, changed)
+
+	cache.Set("foo", []int{1, 2})
+	assert.True(t, changed)
+}
+
+func TestKeyFunc(t *testing.T) {
+	var removed interface{}
+
+	cache := New(Config{
+		Capacity: 2,
+		KeyFunc: func(key interface{}) interface{} {
+			return strings.ToLower(key.(string))
+		},
+	})
+
+	cache.Set("Foo", 1)
+	cache.OnKeyRemoved("FOO", false, func(value interface{}, reason RemoveReason) {
+		removed = value
+	})
+
+	value, ok := cache.Get("foo")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.True(t, cache.Has("fOO"))
+	assert.Equal(t, []interface{}{"foo"}, cache.Keys())
+
+	assert.True(t, cache.Rename("FOO", "Bar"))
+	assert.Equal(t, []interface{}{"bar"}, cache.Keys())
+	assert.True(t, cache.Rename("BAR", "Foo"))
+	assert.True(t, cache.Remove("FOO"))
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestShouldCache(t *testing.T) {
+	var blocked bool
+
+	cache := New(Config{
+		Capacity: 2,
+		ShouldCache: func(key, value interface{}) bool {
+			return !blocked || key != "volatile"
+		},
+	})
+
+	cache.Set("volatile", 1)
+	blocked = true
+
+	assert.False(t, cache.Set("volatile", 2))
+	assert.False(t, cache.Has("volatile"))
+
+	cache.RefreshCache(map[interface{}]interface{}{"foo": 1, "volatile": 3})
+	assert.Equal(t, []interface{}{"foo"}, cache.Keys())
+	assert.Equal(t, int64(2), cache.Stats().Sets)
+}
+
+func TestCacheBytesBand(t *testing.T) {
+	cache := New(Config{
+		Capacity:      4,
+		SizeFunc:      func(key, value interface{}) int64 { return int64(len(value.(string))) },
+		MinCacheBytes: 2,
+		MaxCacheBytes: 4,
+	})
+
+	cache.Set("small", "a")
+	cache.Set("fit", "abc")
+	cache.Set("large", "abcde")
+	assert.Equal(t, []interface{}{"fit"}, cache.Keys())
+
+	cache.Set("fit", "abcdef")
+	assert.False(t, cache.Has("fit"))
+
+	stats := cache.Stats()
+	assert.Equal(t, int64(1), stats.Sets)
+	assert.Equal(t, int64(3), stats.SkippedBySize)
+
+	err := Config{Capacity: 1, MinCacheBytes: 4, MaxCacheBytes: 2}.Validate()
+	assert.EqualError(t, err, "config.MinCacheBytes must be less than or equal to config.MaxCacheBytes; "+
+		"config.SizeFunc is required with config.MinCacheBytes or config.MaxCacheBytes")
+}
+
+func TestEntryPool(t *testing.T) {
+	cache := New(Config{Capacity: 2})
+
+	for i := 0; i < 100; i++ {
+		key, value, evicted := cache.SetAndReport(i, i)
+		if evicted {
+			assert.Equal(t, key, value)
+		}
+		if i%3 == 0 {
+			cache.Remove(i - 1)
+		}
+	}
+
+	for _, key := range cache.Keys() {
+		value, ok := cache.Peek(key)
+		assert.True(t, ok)
+		assert.Equal(t, key, value)
+	}
+}
+
+func TestEntryPoolRetained(t *testing.T) {
+	var seen []Entry
+	events := make(chan Event, 100)
+
+	cache := New(Config{
+		Capacity: 2,
+		OnEviction: func(key, value interface{}) {
+			seen = append(seen, Entry{Key: key, Value: value})
+		},
+		OnRemoval: func(key, value interface{}, reason RemoveReason) {
+			seen = append(seen, Entry{Key: key, Value: value})
+		},
+		Events: events,
+	})
+
+	for i := 0; i < 20; i++ {
+		if key, value, evicted := cache.SetAndReport(i, -i); evicted {
+			seen = append(seen, Entry{Key: key, Value: value})
+		}
+		if i%3 == 0 {
+			cache.Remove(i - 1)
+		}
+	}
+	for len(events) > 0 {
+		event := <-events
+		seen = append(seen, Entry{Key: event.Key, Value: event.Value})
+	}
+	assert.NotEmpty(t, seen)
+
+	// Later inserts reuse the pooled entries of the items seen above
+	for i := 20; i < 100; i++ {
+		cache.Set(i, -i)
+		cache.Remove(i)
+	}
+
+	for _, entry := range seen {
+		assert.Equal(t, -entry.Key.(int), entry.Value)
+	}
+}
+
+func TestMaxCost(t *testing.T) {
+	var evicted []interface{}
+
+	cache := New(Config{
+		Capacity: 10,
+		MaxCost:  10,
+		CostFunc: func(key, value interface{}) int64 {
+			return int64(len(value.(string)))
+		},
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+
+	cache.Set("a", "aaaa")
+	cache.Set("b", "bbbb")
+	assert.Empty(t, evicted)
+
+	evict := cache.Set("c", "cccc")
+	assert.True(t, evict)
+	assert.Equal(t, []interface{}{"a"}, evicted)
+
+	cache.Set("b", "bbbbbbbb")
+	assert.Equal(t, []interface{}{"a", "c"}, evicted)
+	assert.Equal(t, int64(8), cache.Stats().Cost)
+
+	cache.Remove("b")
+	assert.Equal(t, int64(0), cache.Stats().Cost)
+}
+
+func TestInvalidMaxCost(t *testing.T) {
+	assert.Panics(t, func() {
+		New(Config{Capacity: 1, MaxCost: 1})
+	})
+}
+
+func TestSampledEviction(t *testing.T) {
+	var evicted []interface{}
+
+	cache := New(Config{
+		Capacity:   3,
+		SampleSize: 4,
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, 1)
+		time.Sleep(time.Millisecond)
+	}
+	cache.Get("a")
+
+	assert.Equal(t, []interface{}{"a", "b", "c"}, cache.OrderedKeys())
+
+	cache.Set("d", 1)
+	assert.Equal(t, []interface{}{"b"}, evicted)
+}
+
+func TestSampledGetShared(t *testing.T) {
+	cache := New(Config{Capacity: 10, SampleSize: 2})
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				cache.Get("a")
+				cache.Get("c")
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := cache.Stats()
+	assert.Equal(t, int64(2000), stats.Gets)
+	assert.Equal(t, int64(1000), stats.Hits)
+	assert.Equal(t, int64(1000), stats.Misses)
+	assert.Equal(t, "a", cache.ByLastAccess()[0].Key)
+}
+
+func TestSampledEvictionSparesNewItem(t *testing.T) {
+	cache := New(Config{Capacity: 2, SampleSize: 1})
+	for i := 0; i < 200; i++ {
+		cache.Set(i, i)
+		assert.True(t, cache.Has(i), "set %d evicted itself", i)
+	}
+}
+
+func TestCostAwareEvictionSparesNewItem(t *testing.T) {
+	cache := New(Config{
+		Capacity:       2,
+		SampleSize:     1,
+		EvictionPolicy: CostAwareEviction,
+		CostFunc:       func(key, value interface{}) int64 { return 1 },
+	})
+	for i := 0; i < 200; i++ {
+		cache.Set(i, i)
+		assert.True(t, cache.Has(i), "set %d evicted itself", i)
+	}
+}
+
+func TestCostAwareEviction(t *testing.T) {
+	var evicted []interface{}
+
+	cache := New(Config{
+		Capacity:       3,
+		SampleSize:     4,
+		EvictionPolicy: CostAwareEviction,
+		CostFunc: func(key, value interface{}) int64 {
+			return int64(len(value.(string)))
+		},
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+
+	cache.Set("a", "a")
+	time.Sleep(5 * time.Millisecond)
+	cache.Set("b", "b")
+	cache.Set("c", strings.Repeat("c", 100))
+	time.Sleep(5 * time.Millisecond)
+
+	cache.Set("d", "d")
+	assert.Equal(t, []interface{}{"c"}, evicted)
+
+	assert.Panics(t, func() {
+		New(Config{Capacity: 3, EvictionPolicy: CostAwareEviction})
+	})
+}
+
+func TestMaxPerGroup(t *testing.T) {
+	var evicted []interface{}
+
+	cache := New(Config{
+		Capacity:    4,
+		MaxPerGroup: 2,
+		GroupFunc: func(key interface{}) string {
+			return key.(string)[:1]
+		},
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+
+	cache.Set("b1", 1)
+	cache.Set("a1", 1)
+	cache.Set("a2", 2)
+	evict := cache.Set("a3", 3)
+
+	assert.True(t, evict)
+	assert.Equal(t, []interface{}{"a1"}, evicted)
+	assert.Equal(t, []interface{}{"b1", "a2", "a3"}, cache.OrderedKeys())
+
+	cache.Remove("a2")
+	cache.Set("a4", 4)
+	assert.Equal(t, []interface{}{"a1"}, evicted)
+	assert.Equal(t, map[string]int{"a": 2, "b": 1}, cache.groups)
+}
+
+func TestInvalidMaxPerGroup(t *testing.T) {
+	assert.Panics(t, func() {
+		New(Config{Capacity: 1, MaxPerGroup: 1})
+	})
+}
+
+func TestOverflow(t *testing.T) {
+	var evicted []interface{}
+
+	overflow := New(Config{Capacity: 1})
+	cache := New(Config{
+		Capacity: 1,
+		MaxAge:   time.Hour,
+		Overflow: overflow,
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+
+	cache.Set("foo", 1)
+	evict := cache.Set("bar", 2)
+
+	assert.True(t, evict)
+	assert.Empty(t, evicted)
+	assert.False(t, cache.Has("foo"))
+	assert.True(t, overflow.Has("foo"))
+
+	val, ok := cache.Get("foo")
+	assert.True(t, ok)
+	assert.Equal(t, 1, val)
+	assert.True(t, cache.Has("foo"))
+	assert.False(t, overflow.Has("foo"))
+	assert.True(t, overflow.Has("bar"))
+	assert.Equal(t, int64(1), cache.Stats().Hits)
+}
+
+func TestOverflowPromotionRefused(t *testing.T) {
+	overflow := New(Config{Capacity: 2})
+	cache := New(Config{
+		Capacity:       1,
+		Overflow:       overflow,
+		OverflowPolicy: RejectNewOverflow,
+		ShouldCache: func(key, value interface{}) bool {
+			return key != "blocked"
+		},
+	})
+	cache.Set("foo", 1)
+	overflow.Set("bar", 2)
+	overflow.Set("blocked", 3)
+
+	value, ok := cache.Get("bar")
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+	assert.True(t, overflow.Has("bar"))
+
+	cache.Remove("foo")
+	value, ok = cache.Get("blocked")
+	assert.True(t, ok)
+	assert.Equal(t, 3, value)
+	assert.True(t, overflow.Has("blocked"))
+	assert.False(t, cache.Has("blocked"))
+
+	cache.Get("bar")
+	assert.True(t, cache.Has("bar"))
+	assert.False(t, overflow.Has("bar"))
+}
+
+func TestOverflowCycle(t *testing.T) {
+	a := New(Config{Capacity: 1})
+	b := New(Config{Capacity: 1, Overflow: a})
+
+	assert.Error(t, a.Reconfigure(Config{Capacity: 1, Overflow: a}))
+	assert.Error(t, a.Reconfigure(Config{Capacity: 1, Overflow: b}))
+
+	a.Set("foo", 1)
+	a.Set("bar", 2)
+	assert.Equal(t, []interface{}{"bar"}, a.Keys())
+	assert.Empty(t, b.Keys())
+}
+
+func TestOverflowPreservesTTL(t *testing.T) {
+	overflow := New(Config{Capacity: 1, MaxAge: 2 * time.Hour})
+	cache := New(Config{Capacity: 1, MaxAge: time.Hour, Overflow: overflow})
+
+	cache.Set("foo", 1)
+	expiresAt, _ := cache.ExpiresAt("foo")
+	cache.Set("bar", 2)
+
+	spilled, ok := overflow.ExpiresAt("foo")
+	assert.True(t, ok)
+	assert.Equal(t, expiresAt, spilled)
+}
+
+func TestOverflowPreservesLifetime(t *testing.T) {
+	overflow := New(Config{Capacity: 2})
+	cache := New(Config{Capacity: 1, MaxLifetime: 50 * time.Millisecond, Overflow: overflow})
+
+	cache.Set("foo", 1)
+	createdAt, _ := cache.CreatedAt("foo")
+	cache.Set("bar", 2)
+
+	spilled, ok := overflow.CreatedAt("foo")
+	assert.True(t, ok)
+	assert.Equal(t, createdAt, spilled)
+
+	_, ok = cache.Get("foo")
+	assert.True(t, ok)
+	promoted, _ := cache.CreatedAt("foo")
+	assert.Equal(t, createdAt, promoted)
+
+	cache.Set("bar", 2)
+	<-time.After(60 * time.Millisecond)
+	_, ok = cache.Get("foo")
+	assert.False(t, ok)
+	assert.False(t, overflow.Has("foo"))
+}
+
+func TestOnFull(t *testing.T) {
+	var transitions []string
+
+	cache := New(Config{
+		Capacity: 2,
+		OnFull: func() {
+			transitions = append(transitions, "full")
+		},
+		OnNotFull: func() {
+			transitions = append(transitions, "not full")
+		},
+	})
+
+	cache.Set("a", 1)
+	assert.Empty(t, transitions)
+
+	cache.Set("b", 2)
+	cache.Set("c", 3)
+	cache.Set("d", 4)
+	assert.Equal(t, []string{"full"}, transitions)
+
+	cache.Remove("d")
+	cache.Remove("c")
+	assert.Equal(t, []string{"full", "not full"}, transitions)
+
+	cache.Set("c", 3)
+	cache.Set("e", 5)
+	cache.Resize(3)
+	assert.Equal(t, []string{"full", "not full", "full", "not full"}, transitions)
+}
+
+func TestExpiration(t *testing.T) {
+	var k, v interface{}
+	var eviction bool
+
+	cache := New(Config{
+		Capacity: 1,
+		MaxAge:   time.Millisecond,
+		OnExpiration: func(key, value interface{}) {
+			k = key
+			v = value
+		},
+		OnEviction: func(key, value interface{}) {
+			eviction = true
+		},
+	})
+
+	cache.Set("foo", 1)
+	<-time.After(time.Millisecond * 2)
+
+	val, ok := cache.Get("foo")
+	assert.False(t, ok)
+	assert.Nil(t, val)
+	assert.Equal(t, "foo", k)
+	assert.Equal(t, 1, v)
+	assert.False(t, eviction)
+}
+
+func TestExtendOnReadWithin(t *testing.T) {
+	cache := New(Config{
+		Capacity:           1,
+		MaxAge:             time.Hour,
+		ExtendOnReadWithin: time.Minute,
+	})
+
+	cache.Set("foo", 1)
+	expiresAt, _ := cache.ExpiresAt("foo")
+	cache.Get("foo")
+	extended, _ := cache.ExpiresAt("foo")
+	assert.Equal(t, expiresAt, extended)
+
+	// Move the entry within a minute of expiring
+	cache.items["foo"].Value.(*cacheEntry).timestamp = time.Now().Add(-59 * time.Minute)
+	before := time.Now()
+	_, ok := cache.Get("foo")
+	assert.True(t, ok)
+
+	extended, _ = cache.ExpiresAt("foo")
+	assert.False(t, extended.Before(before.Add(time.Hour)))
+}
+
+func TestMaxLifetime(t *testing.T) {
+	var expired bool
+
+	cache := New(Config{
+		Capacity:           1,
+		MaxAge:             time.Hour,
+		MaxLifetime:        2 * time.Hour,
+		ExtendOnReadWithin: time.Hour,
+		OnExpiration: func(key, value interface{}) {
+			expired = true
+		},
+	})
+
+	cache.Set("foo", 1)
+	expiresAt, _ := cache.ExpiresAt("foo")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Second)
+
+	// Move the entry past its lifetime, though it was just set again
+	entry := cache.items["foo"].Value.(*cacheEntry)
+	entry.createdAt = time.Now().Add(-90 * time.Minute)
+	cache.Set("foo", 2)
+	expiresAt, _ = cache.ExpiresAt("foo")
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), expiresAt, time.Second)
+
+	entry.createdAt = time.Now().Add(-3 * time.Hour)
+	_, ok := cache.Get("foo")
+	assert.False(t, ok)
+	assert.True(t, expired)
+
+	assert.Panics(t, func() {
+		New(Config{Capacity: 1, MaxLifetime: -1 * time.Hour})
+	})
+}
+
+func TestMaxLifetimeSetAfterExpiry(t *testing.T) {
+	var expired []interface{}
+
+	cache := New(Config{
+		Capacity:    2,
+		MaxLifetime: time.Hour,
+		OnExpiration: func(key, value interface{}) {
+			expired = append(expired, value)
+		},
+	})
+
+	cache.Set("foo", 1)
+	cache.items["foo"].Value.(*cacheEntry).createdAt = time.Now().Add(-2 * time.Hour)
+	cache.Set("foo", 2)
+
+	value, ok := cache.Get("foo")
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+	assert.Equal(t, []interface{}{1}, expired)
+
+	cache.items["foo"].Value.(*cacheEntry).createdAt = time.Now().Add(-2 * time.Hour)
+	assert.Equal(t, int64(5), cache.IncrementOrSet("foo", 1, 5))
+	assert.Equal(t, int64(6), cache.IncrementOrSet("foo", 1, 5))
+
+	stats := cache.Stats()
+	assert.Equal(t, int64(3), stats.Inserts)
+	assert.Equal(t, int64(1), stats.Overwrites)
+}
+
+func TestIncrementOrSet(t *testing.T) {
+	cache := New(Config{Capacity: 2, MaxAge: time.Hour})
+
+	assert.Equal(t, int64(1), cache.IncrementOrSet("foo", 5, 1))
+	assert.Equal(t, int64(6), cache.IncrementOrSet("foo", 5, 1))
+
+	cache.items["foo"].Value.(*cacheEntry).timestamp = time.Now().Add(-2 * time.Hour)
+	assert.Equal(t, int64(1), cache.IncrementOrSet("foo", 5, 1))
+
+	cache.Set("bar", "baz")
+	assert.Equal(t, int64(0), cache.IncrementOrSet("bar", 1, 0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.IncrementOrSet("bar", 1, 1)
+		}()
+	}
+	wg.Wait()
+
+	value, _ := cache.Get("bar")
+	assert.Equal(t, int64(10), value)
+}
+
+func TestIncrementOrSetIntegerKinds(t *testing.T) {
+	cache := New(Config{Capacity: 10})
+	cache.Set("int", 5)
+	cache.Set("uint8", uint8(255))
+	cache.Set("duration", time.Second)
+	cache.Set("string", "5")
+	cache.Set("nil", nil)
+
+	assert.Equal(t, int64(6), cache.IncrementOrSet("int", 1, 0))
+	assert.Equal(t, int64(0), cache.IncrementOrSet("uint8", 1, 0))
+	assert.Equal(t, int64(time.Second+1), cache.IncrementOrSet("duration", 1, 0))
+	assert.Equal(t, int64(0), cache.IncrementOrSet("string", 1, 0))
+	assert.Equal(t, int64(0), cache.IncrementOrSet("nil", 1, 0))
+
+	value, _ := cache.Get("int")
+	assert.Equal(t, 6, value)
+	value, _ = cache.Get("uint8")
+	assert.Equal(t, uint8(0), value)
+	value, _ = cache.Get("duration")
+	assert.Equal(t, time.Second+1, value)
+	value, _ = cache.Get("string")
+	assert.Equal(t, int64(0), value)
+}
+
+func TestIncrementPreservesTTL(t *testing.T) {
+	cache := New(Config{Capacity: 1, MaxAge: time.Hour, IncrementPreservesTTL: true})
+
+	cache.IncrementOrSet("foo", 1, 1)
+	expiresAt, _ := cache.ExpiresAt("foo")
+	time.Sleep(time.Millisecond)
+	cache.IncrementOrSet("foo", 1, 1)
+	incremented, _ := cache.ExpiresAt("foo")
+	assert.Equal(t, expiresAt, incremented)
+}
+
+func TestIncrementOrSetAsSet(t *testing.T) {
+	var buf bytes.Buffer
+	var changes [][]interface{}
+
+	cache := New(Config{
+		Capacity: 2,
+		Recorder: NewRecorder(&buf),
+		OnChange: func(key, old, new interface{}) {
+			changes = append(changes, []interface{}{key, old, new})
+		},
+		ShouldCache: func(key, value interface{}) bool {
+			return value.(int64) < 10
+		},
 	})
+
+	assert.Equal(t, int64(1), cache.IncrementOrSet("foo", 5, 1))
+	assert.Equal(t, int64(6), cache.IncrementOrSet("foo", 5, 1))
+	assert.Equal(t, [][]interface{}{{"foo", int64(1), int64(6)}}, changes)
+
+	// A rejected result removes the previous value, as Set would
+	assert.Equal(t, int64(11), cache.IncrementOrSet("foo", 5, 1))
+	assert.False(t, cache.Has("foo"))
+	assert.Equal(t, int64(2), cache.Stats().Sets)
+	assert.Equal(t, int64(1), cache.IncrementOrSet("foo", 5, 1))
+
+	replayed := New(Config{Capacity: 2})
+	assert.NoError(t, Replay(&buf, replayed))
+	assert.Equal(t, cache.OrderedKeys(), replayed.OrderedKeys())
 }
 
-func TestBasicSetGet(t *testing.T) {
+func TestVersioning(t *testing.T) {
 	cache := New(Config{Capacity: 2})
-	cache.Set("foo", 1)
-	cache.Set("bar", 2)
 
-	val, ok := cache.Get("foo")
-	assert.True(t, ok)
-	assert.Equal(t, 1, val)
+	assert.True(t, cache.SetIfVersion("foo", 1, 0))
+	assert.False(t, cache.SetIfVersion("foo", 2, 0))
 
-	val, ok = cache.Get("bar")
+	value, version, ok := cache.GetVersioned("foo")
 	assert.True(t, ok)
-	assert.Equal(t, 2, val)
+	assert.Equal(t, 1, value)
+	assert.NotZero(t, version)
+
+	assert.True(t, cache.SetIfVersion("foo", 2, version))
+	assert.False(t, cache.SetIfVersion("foo", 3, version))
+
+	cache.Set("foo", 4)
+	value, newVersion, _ := cache.GetVersioned("foo")
+	assert.Equal(t, 4, value)
+	assert.Greater(t, newVersion, version)
+
+	_, version, ok = cache.GetVersioned("bar")
+	assert.False(t, ok)
+	assert.Zero(t, version)
 }
 
-func TestBasicSetOverwrite(t *testing.T) {
-	cache := New(Config{Capacity: 2})
+func TestGetVersionedFromOverflow(t *testing.T) {
+	overflow := New(Config{Capacity: 1})
+	cache := New(Config{Capacity: 1, Overflow: overflow, OverflowPolicy: RejectNewOverflow})
 	cache.Set("foo", 1)
-	evict := cache.Set("foo", 2)
-	val, ok := cache.Get("foo")
+	overflow.Set("bar", 2)
 
-	assert.False(t, evict)
+	value, version, ok := cache.GetVersioned("bar")
 	assert.True(t, ok)
-	assert.Equal(t, 2, val)
+	assert.Equal(t, 2, value)
+	assert.Zero(t, version)
+	assert.False(t, cache.Has("bar"))
 }
 
-func TestEviction(t *testing.T) {
-	var k, v interface{}
+func TestSetIfVersionAsSet(t *testing.T) {
+	var buf bytes.Buffer
+	var changes [][]interface{}
 
 	cache := New(Config{
 		Capacity: 2,
-		OnEviction: func(key, value interface{}) {
-			k = key
-			v = value
+		Recorder: NewRecorder(&buf),
+		OnChange: func(key, old, new interface{}) {
+			changes = append(changes, []interface{}{key, old, new})
 		},
 	})
 
+	assert.True(t, cache.SetIfVersion("foo", 1, 0))
+	_, version, _ := cache.GetVersioned("foo")
+	assert.False(t, cache.SetIfVersion("foo", 2, 0))
+	assert.True(t, cache.SetIfVersion("foo", 3, version))
+	assert.Equal(t, [][]interface{}{{"foo", 1, 3}}, changes)
+
+	replayed := New(Config{Capacity: 2})
+	assert.NoError(t, Replay(&buf, replayed))
+	value, ok := replayed.Get("foo")
+	assert.True(t, ok)
+	assert.EqualValues(t, 3, value)
+	assert.Equal(t, int64(2), replayed.Stats().Sets)
+}
+
+func TestGetWithFreshness(t *testing.T) {
+	cache := New(Config{Capacity: 1, MaxAge: time.Hour, SoftMaxAge: time.Minute})
+
 	cache.Set("foo", 1)
-	cache.Set("bar", 2)
-	evict := cache.Set("baz", 3)
-	val, ok := cache.Get("foo")
+	value, fresh, present := cache.GetWithFreshness("foo")
+	assert.Equal(t, 1, value)
+	assert.True(t, fresh)
+	assert.True(t, present)
 
-	assert.True(t, evict)
+	cache.items["foo"].Value.(*cacheEntry).timestamp = time.Now().Add(-2 * time.Minute)
+	value, fresh, present = cache.GetWithFreshness("foo")
+	assert.Equal(t, 1, value)
+	assert.False(t, fresh)
+	assert.True(t, present)
+
+	cache.items["foo"].Value.(*cacheEntry).timestamp = time.Now().Add(-2 * time.Hour)
+	value, fresh, present = cache.GetWithFreshness("foo")
+	assert.Nil(t, value)
+	assert.False(t, fresh)
+	assert.False(t, present)
+
+	assert.Panics(t, func() {
+		New(Config{Capacity: 1, MaxAge: time.Minute, SoftMaxAge: time.Hour})
+	})
+
+	overflow := New(Config{Capacity: 1})
+	disabled := New(Config{Capacity: 0, Overflow: overflow})
+	overflow.Set("foo", 1)
+	value, fresh, present = disabled.GetWithFreshness("foo")
+	assert.Equal(t, 1, value)
+	assert.False(t, fresh)
+	assert.True(t, present)
+}
+
+func TestDeadlineFunc(t *testing.T) {
+	cache := New(Config{
+		Capacity: 2,
+		MaxAge:   time.Hour,
+		DeadlineFunc: func(key, value interface{}) time.Time {
+			deadline, _ := value.(time.Time)
+			return deadline
+		},
+	})
+
+	deadline := time.Now().Add(time.Minute)
+	cache.Set("token", deadline)
+	expiresAt, _ := cache.ExpiresAt("token")
+	assert.Equal(t, deadline, expiresAt)
+
+	cache.Set("token", time.Now().Add(-time.Second))
+	_, ok := cache.Get("token")
 	assert.False(t, ok)
-	assert.Nil(t, val)
-	assert.Equal(t, "foo", k)
-	assert.Equal(t, 1, v)
+
+	cache.Set("foo", 1)
+	expiresAt, _ = cache.ExpiresAt("foo")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Second)
 }
 
-func TestExpiration(t *testing.T) {
-	var k, v interface{}
-	var eviction bool
+func TestSetNoExtend(t *testing.T) {
+	cache := New(Config{
+		Capacity:           2,
+		MaxAge:             time.Hour,
+		ExtendOnReadWithin: time.Minute,
+	})
+
+	cache.SetNoExtend("secret", 1)
+	cache.Set("session", 2)
+
+	// Move the entries within a minute of expiring
+	for _, key := range []string{"secret", "session"} {
+		cache.items[key].Value.(*cacheEntry).timestamp = time.Now().Add(-59 * time.Minute)
+	}
+	cache.Get("secret")
+	cache.Get("session")
+
+	secret, _ := cache.ExpiresAt("secret")
+	session, _ := cache.ExpiresAt("session")
+	assert.True(t, secret.Before(time.Now().Add(time.Minute)))
+	assert.True(t, session.After(time.Now().Add(59*time.Minute)))
+
+	cache.Set("secret", 3)
+	assert.False(t, cache.items["secret"].Value.(*cacheEntry).noExtend)
+}
+
+func TestSetWithDeps(t *testing.T) {
+	t.Run("cascades removal", func(t *testing.T) {
+		var removed []interface{}
+		cache := New(Config{
+			Capacity: 10,
+			OnRemoval: func(key, value interface{}, reason RemoveReason) {
+				removed = append(removed, key)
+			},
+		})
+		cache.Set("user", 1)
+		cache.Set("org", 2)
+		cache.SetWithDeps("profile", 3, "user", "org")
+		cache.SetWithDeps("page", 4, "profile")
+		cache.Set("other", 5)
+
+		cache.Set("org", 6)
+		assert.True(t, cache.Has("page"))
+
+		cache.Remove("org")
+		assert.ElementsMatch(t, []interface{}{"org", "profile", "page"}, removed)
+		assert.ElementsMatch(t, []interface{}{"user", "other"}, cache.Keys())
+		assert.Empty(t, cache.dependents)
+	})
+
+	t.Run("cascades eviction and expiration", func(t *testing.T) {
+		cache := New(Config{Capacity: 3, MaxAge: time.Hour})
+		cache.Set("base", 1)
+		cache.SetWithDeps("derived", 2, "base")
+		cache.Set("a", 3)
+		cache.Set("b", 4)
+		assert.ElementsMatch(t, []interface{}{"a", "b"}, cache.Keys())
+
+		cache.SetWithDeps("derived", 5, "a")
+		cache.items["a"].Value.(*cacheEntry).timestamp = time.Now().Add(-2 * time.Hour)
+		cache.Get("a")
+		assert.Equal(t, []interface{}{"b"}, cache.Keys())
+	})
+
+	t.Run("clears dependencies on set", func(t *testing.T) {
+		cache := New(Config{Capacity: 10})
+		cache.Set("base", 1)
+		cache.SetWithDeps("derived", 2, "base")
+		cache.Set("derived", 3)
+
+		cache.Remove("base")
+		assert.True(t, cache.Has("derived"))
+		assert.Empty(t, cache.dependents)
+	})
+
+	t.Run("handles cycles", func(t *testing.T) {
+		cache := New(Config{Capacity: 10})
+		cache.SetWithDeps("a", 1, "c", "a")
+		cache.SetWithDeps("b", 2, "a")
+		cache.SetWithDeps("c", 3, "b")
+		cache.Set("d", 4)
+
+		cache.Remove("b")
+		assert.Equal(t, []interface{}{"d"}, cache.Keys())
+		assert.Empty(t, cache.dependents)
+	})
+
+	t.Run("rename removes dependents", func(t *testing.T) {
+		cache := New(Config{Capacity: 10})
+		cache.Set("base", 1)
+		cache.SetWithDeps("derived", 2, "base")
+		cache.SetWithDeps("other", 3, "derived")
+
+		assert.True(t, cache.Rename("other", "derived"))
+		assert.Equal(t, []interface{}{"base"}, cache.Keys())
+		assert.Empty(t, cache.dependents)
+
+		cache.SetWithDeps("derived", 2, "base")
+		assert.True(t, cache.Rename("base", "renamed"))
+		assert.Equal(t, []interface{}{"renamed"}, cache.Keys())
+		assert.Empty(t, cache.dependents)
+	})
+}
+
+func TestInvalidExtendOnReadWithin(t *testing.T) {
+	assert.Panics(t, func() {
+		New(Config{Capacity: 1, ExtendOnReadWithin: -1 * time.Minute})
+	})
+}
+
+func TestGetManyReaping(t *testing.T) {
+	var expired []interface{}
 
 	cache := New(Config{
-		Capacity: 1,
+		Capacity: 10,
 		MaxAge:   time.Millisecond,
 		OnExpiration: func(key, value interface{}) {
-			k = key
-			v = value
-		},
-		OnEviction: func(key, value interface{}) {
-			eviction = true
+			expired = append(expired, key)
 		},
 	})
-
 	cache.Set("foo", 1)
 	<-time.After(time.Millisecond * 2)
+	cache.Set("bar", 2)
 
-	val, ok := cache.Get("foo")
-	assert.False(t, ok)
-	assert.Nil(t, val)
-	assert.Equal(t, "foo", k)
-	assert.Equal(t, 1, v)
-	assert.False(t, eviction)
+	values := cache.GetManyReaping([]interface{}{"foo", "bar", "baz"})
+	assert.Equal(t, map[interface{}]interface{}{"bar": 2}, values)
+	assert.Equal(t, []interface{}{"foo"}, expired)
+	assert.False(t, cache.Has("foo"))
+
+	stats := cache.Stats()
+	assert.Equal(t, int64(3), stats.Gets)
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(2), stats.Misses)
 }
 
 func TestCacheBackgroundRefresh(t *testing.T) {
@@ -143,6 +1259,85 @@ func TestCacheBackgroundRefresh(t *testing.T) {
 
 }
 
+func TestAutoRefresh(t *testing.T) {
+	reloaded := make(chan interface{}, 10)
+
+	cache := New(Config{
+		Capacity:            3,
+		AutoRefreshInterval: 5 * time.Millisecond,
+		Reloader: func(key, value interface{}) (interface{}, error) {
+			defer func() { reloaded <- key }()
+			if key == "b" {
+				return nil, errors.New("reload failed")
+			}
+			return value.(int) + 10, nil
+		},
+	})
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Set("c", 3)
+	cache.Get("a")
+
+	seen := map[interface{}]bool{}
+	for len(seen) < 3 {
+		seen[<-reloaded] = true
+	}
+	cache.Reconfigure(Config{Capacity: 3})
+
+	// Items may have been reloaded more than once
+	a, _ := cache.Peek("a")
+	b, _ := cache.Peek("b")
+	c, _ := cache.Peek("c")
+	assert.True(t, a.(int) > 1 && a.(int)%10 == 1)
+	assert.Equal(t, 2, b)
+	assert.True(t, c.(int) > 3 && c.(int)%10 == 3)
+	assert.Equal(t, []interface{}{"b", "c", "a"}, cache.OrderedKeys())
+}
+
+func TestReloadSkipsUpdatedItems(t *testing.T) {
+	cache := New(Config{Capacity: 2})
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+
+	cache.reloadAll(func(key, value interface{}) (interface{}, error) {
+		if key == "a" {
+			cache.Set("a", 100)
+		}
+		return value.(int) + 10, nil
+	})
+
+	a, _ := cache.Peek("a")
+	b, _ := cache.Peek("b")
+	assert.Equal(t, 100, a)
+	assert.Equal(t, 12, b)
+}
+
+func TestReloadAsSet(t *testing.T) {
+	var changes [][]interface{}
+	cache := New(Config{
+		Capacity: 3,
+		ShouldCache: func(key, value interface{}) bool {
+			return value.(int) < 100
+		},
+		OnChange: func(key, old, new interface{}) {
+			changes = append(changes, []interface{}{key, old, new})
+		},
+	})
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Set("c", 90)
+
+	cache.reloadAll(func(key, value interface{}) (interface{}, error) {
+		if key == "b" {
+			return value, nil
+		}
+		return value.(int) + 10, nil
+	})
+
+	assert.Equal(t, [][]interface{}{{"a", 1, 11}}, changes)
+	assert.Equal(t, []interface{}{"a", "b"}, cache.OrderedKeys())
+}
+
 func TestCacheBackgroundRefreshForNilData(t *testing.T) {
 	count := 0
 	cache := New(Config{
@@ -226,28 +1421,211 @@ func TestJitter(t *testing.T) {
 	_, ok = cache.Get("foo")
 	assert.True(t, ok)
 
-	time.Sleep(100 * time.Millisecond)
-	_, ok = cache.Get("foo")
-	assert.False(t, ok)
+	time.Sleep(100 * time.Millisecond)
+	_, ok = cache.Get("foo")
+	assert.False(t, ok)
+}
+
+func TestJitterLargeWindow(t *testing.T) {
+	cache := New(Config{
+		Capacity: 100,
+		MaxAge:   10 * time.Minute,
+		MinAge:   time.Minute,
+	})
+
+	before := time.Now()
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i)
+	}
+	after := time.Now()
+
+	for i := 0; i < 100; i++ {
+		expiresAt, ok := cache.ExpiresAt(i)
+		assert.True(t, ok)
+		assert.False(t, expiresAt.Before(before.Add(time.Minute)))
+		assert.False(t, expiresAt.After(after.Add(10*time.Minute)))
+	}
+}
+
+func TestHas(t *testing.T) {
+	cache := New(Config{Capacity: 1, MaxAge: time.Millisecond})
+	cache.Set("foo", "bar")
+	<-time.After(time.Millisecond * 2)
+
+	ok := cache.Has("foo")
+	assert.True(t, ok)
+}
+
+func TestHasReapsExpired(t *testing.T) {
+	var expiration bool
+
+	cache := New(Config{
+		Capacity:        1,
+		MaxAge:          time.Millisecond,
+		HasReapsExpired: true,
+		OnExpiration: func(key, value interface{}) {
+			expiration = true
+		},
+	})
+	cache.Set("foo", "bar")
+	assert.True(t, cache.Has("foo"))
+
+	<-time.After(time.Millisecond * 2)
+
+	assert.False(t, cache.Has("foo"))
+	assert.True(t, expiration)
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestPeek(t *testing.T) {
+	cache := New(Config{Capacity: 1, MaxAge: time.Millisecond})
+	cache.Set("foo", "bar")
+	<-time.After(time.Millisecond * 2)
+
+	val, ok := cache.Peek("foo")
+	assert.True(t, ok)
+	assert.Equal(t, "bar", val)
+}
+
+func TestGetWithContext(t *testing.T) {
+	type traceKey struct{}
+	var traces []bool
+
+	cache := New(Config{
+		Capacity: 1,
+		Tracer: func(ctx context.Context, op string, hit bool) {
+			assert.Equal(t, "span", ctx.Value(traceKey{}))
+			assert.Equal(t, "get", op)
+			traces = append(traces, hit)
+		},
+	})
+	cache.Set("foo", "bar")
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "span")
+	val, ok := cache.GetWithContext(ctx, "foo")
+	assert.True(t, ok)
+	assert.Equal(t, "bar", val)
+
+	_, ok = cache.GetWithContext(ctx, "baz")
+	assert.False(t, ok)
+	assert.Equal(t, []bool{true, false}, traces)
+}
+
+func TestWaitFor(t *testing.T) {
+	cache := New(Config{Capacity: 2})
+	cache.Set("foo", 1)
+
+	value, ok := cache.WaitFor(context.Background(), "foo")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		cache.Set("bar", 2)
+	}()
+
+	value, ok = cache.WaitFor(context.Background(), "bar")
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	assert.Empty(t, cache.waiters)
+}
+
+func TestWaitForCanceled(t *testing.T) {
+	cache := New(Config{Capacity: 2})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+
+	value, ok := cache.WaitFor(ctx, "foo")
+	assert.False(t, ok)
+	assert.Nil(t, value)
+	assert.Empty(t, cache.waiters)
+}
+
+func TestGetStale(t *testing.T) {
+	var expiration bool
+
+	cache := New(Config{
+		Capacity: 1,
+		MaxAge:   time.Millisecond,
+		OnExpiration: func(key, value interface{}) {
+			expiration = true
+		},
+	})
+	cache.Set("foo", "bar")
+
+	val, stale, ok := cache.GetStale("foo")
+	assert.True(t, ok)
+	assert.False(t, stale)
+	assert.Equal(t, "bar", val)
+
+	<-time.After(time.Millisecond * 2)
+
+	val, stale, ok = cache.GetStale("foo")
+	assert.True(t, ok)
+	assert.True(t, stale)
+	assert.Equal(t, "bar", val)
+	assert.True(t, cache.Has("foo"))
+	assert.False(t, expiration)
+
+	_, _, ok = cache.GetStale("baz")
+	assert.False(t, ok)
+}
+
+func TestCreatedAt(t *testing.T) {
+	cache := New(Config{Capacity: 2})
+
+	_, ok := cache.CreatedAt("foo")
+	assert.False(t, ok)
+
+	before := time.Now()
+	cache.Set("foo", 1)
+	createdAt, ok := cache.CreatedAt("foo")
+	assert.True(t, ok)
+	assert.False(t, createdAt.Before(before))
+
+	time.Sleep(time.Millisecond)
+	cache.Set("foo", 2)
+	overwritten, _ := cache.CreatedAt("foo")
+	assert.Equal(t, createdAt, overwritten)
+
+	cache.Remove("foo")
+	cache.Set("foo", 3)
+	recreated, _ := cache.CreatedAt("foo")
+	assert.True(t, recreated.After(createdAt))
 }
 
-func TestHas(t *testing.T) {
-	cache := New(Config{Capacity: 1, MaxAge: time.Millisecond})
+func TestExpiresAt(t *testing.T) {
+	cache := New(Config{
+		Capacity: 2,
+		MaxAge:   time.Hour,
+		MinAge:   30 * time.Minute,
+	})
+
+	cache.rand = &MockRandGenerator{
+		startAt: (10 * time.Minute).Nanoseconds(),
+	}
+
+	before := time.Now()
 	cache.Set("foo", "bar")
-	<-time.After(time.Millisecond * 2)
+	after := time.Now()
 
-	ok := cache.Has("foo")
+	expiresAt, ok := cache.ExpiresAt("foo")
 	assert.True(t, ok)
-}
+	assert.False(t, expiresAt.Before(before.Add(50*time.Minute)))
+	assert.False(t, expiresAt.After(after.Add(50*time.Minute)))
 
-func TestPeek(t *testing.T) {
-	cache := New(Config{Capacity: 1, MaxAge: time.Millisecond})
-	cache.Set("foo", "bar")
-	<-time.After(time.Millisecond * 2)
+	_, ok = cache.ExpiresAt("baz")
+	assert.False(t, ok)
 
-	val, ok := cache.Peek("foo")
+	noExpiry := New(Config{Capacity: 1})
+	noExpiry.Set("foo", "bar")
+	expiresAt, ok = noExpiry.ExpiresAt("foo")
 	assert.True(t, ok)
-	assert.Equal(t, "bar", val)
+	assert.True(t, expiresAt.IsZero())
 }
 
 func TestRemove(t *testing.T) {
@@ -271,6 +1649,112 @@ func TestRemove(t *testing.T) {
 	assert.Nil(t, val)
 }
 
+func TestOnKeyRemoved(t *testing.T) {
+	var reasons []RemoveReason
+
+	cache := New(Config{Capacity: 1, MaxAge: time.Millisecond})
+	cache.OnKeyRemoved("foo", false, func(value interface{}, reason RemoveReason) {
+		assert.Equal(t, "bar", value)
+		reasons = append(reasons, reason)
+	})
+
+	cache.Set("bar", "baz")
+	cache.Remove("bar")
+	assert.Empty(t, reasons)
+
+	cache.Set("foo", "bar")
+	cache.Set("baz", "qux")
+	assert.Equal(t, []RemoveReason{ReasonEvicted}, reasons)
+
+	// One-shot callbacks are discarded after firing
+	cache.Set("foo", "bar")
+	cache.Remove("foo")
+	assert.Equal(t, []RemoveReason{ReasonEvicted}, reasons)
+}
+
+func TestOnKeyRemovedPersistent(t *testing.T) {
+	var reasons []RemoveReason
+
+	cache := New(Config{Capacity: 1, MaxAge: time.Millisecond})
+	cache.OnKeyRemoved("foo", true, func(value interface{}, reason RemoveReason) {
+		reasons = append(reasons, reason)
+	})
+
+	cache.Set("foo", "bar")
+	cache.Remove("foo")
+
+	cache.Set("foo", "bar")
+	<-time.After(time.Millisecond * 2)
+	cache.Get("foo")
+
+	cache.Set("foo", "bar")
+	cache.Clear()
+
+	assert.Equal(t, []RemoveReason{ReasonRemoved, ReasonExpired, ReasonRemoved}, reasons)
+}
+
+func TestRemoveMany(t *testing.T) {
+	var reasons []RemoveReason
+
+	cache := New(Config{
+		Capacity: 3,
+		OnRemoval: func(key, value interface{}, reason RemoveReason) {
+			reasons = append(reasons, reason)
+		},
+	})
+	cache.Set("foo", 1)
+	cache.Set("bar", 2)
+	cache.Set("baz", 3)
+
+	removed := cache.RemoveMany([]interface{}{"foo", "qux", "baz"})
+	assert.Equal(t, []interface{}{"foo", "baz"}, removed)
+	assert.Equal(t, []interface{}{"bar"}, cache.Keys())
+	assert.Equal(t, []RemoveReason{ReasonRemoved, ReasonRemoved}, reasons)
+}
+
+func TestRename(t *testing.T) {
+	cache := New(Config{Capacity: 3, MaxAge: time.Hour})
+	cache.Set("foo", 1)
+	cache.Set("bar", 2)
+	cache.Set("baz", 3)
+	expiresAt, _ := cache.ExpiresAt("foo")
+
+	ok := cache.Rename("foo", "bar")
+	assert.True(t, ok)
+	assert.False(t, cache.Has("foo"))
+	assert.Equal(t, []interface{}{"bar", "baz"}, cache.OrderedKeys())
+
+	val, _ := cache.Peek("bar")
+	assert.Equal(t, 1, val)
+	renamed, _ := cache.ExpiresAt("bar")
+	assert.Equal(t, expiresAt, renamed)
+
+	assert.False(t, cache.Rename("foo", "qux"))
+	assert.True(t, cache.Rename("bar", "bar"))
+}
+
+func TestRenameIntoFullGroup(t *testing.T) {
+	var evicted []interface{}
+
+	cache := New(Config{
+		Capacity:    4,
+		MaxPerGroup: 1,
+		GroupFunc: func(key interface{}) string {
+			return key.(string)[:1]
+		},
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	cache.Set("a1", 1)
+	cache.Set("b1", 2)
+
+	assert.True(t, cache.Rename("b1", "a2"))
+	assert.Equal(t, []interface{}{"a1"}, evicted)
+	assert.Equal(t, []interface{}{"a2"}, cache.OrderedKeys())
+	assert.Equal(t, map[string]int{"a": 1}, cache.groups)
+}
+
 func TestEvictOldest(t *testing.T) {
 	var eviction bool
 
@@ -323,6 +1807,113 @@ func TestClear(t *testing.T) {
 	assert.Equal(t, 0, cache.Len())
 }
 
+func TestDrainFunc(t *testing.T) {
+	cache := New(Config{Capacity: 10})
+	for i := 0; i < 5; i++ {
+		cache.Set(i, i)
+	}
+
+	var batches [][]Entry
+	err := cache.DrainFunc(2, func(entries []Entry) error {
+		batches = append(batches, entries)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, [][]Entry{
+		{{0, 0}, {1, 1}},
+		{{2, 2}, {3, 3}},
+		{{4, 4}},
+	}, batches)
+	assert.Equal(t, 0, cache.Len())
+
+	assert.Error(t, cache.DrainFunc(0, func(entries []Entry) error {
+		return nil
+	}))
+}
+
+func TestDrainFuncWithDeps(t *testing.T) {
+	cache := New(Config{Capacity: 10})
+	cache.Set("base", 1)
+	cache.SetWithDeps("derived", 2, "base")
+
+	var drained []interface{}
+	err := cache.DrainFunc(1, func(entries []Entry) error {
+		for _, entry := range entries {
+			drained = append(drained, entry.Key)
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"base", "derived"}, drained)
+	assert.Equal(t, 0, cache.Len())
+	assert.Empty(t, cache.dependents)
+}
+
+func TestDrainFuncError(t *testing.T) {
+	cache := New(Config{Capacity: 10})
+	for i := 0; i < 5; i++ {
+		cache.Set(i, i)
+	}
+
+	calls := 0
+	err := cache.DrainFunc(2, func(entries []Entry) error {
+		calls++
+		if calls == 2 {
+			return errors.New("flush failed")
+		}
+		return nil
+	})
+
+	assert.EqualError(t, err, "flush failed")
+	assert.Equal(t, []interface{}{2, 3, 4}, cache.OrderedKeys())
+}
+
+func TestDrainFuncRestore(t *testing.T) {
+	var removed, evicted []interface{}
+	cache := New(Config{
+		Capacity: 5,
+		OnRemoval: func(key, value interface{}, reason RemoveReason) {
+			if reason == ReasonRemoved {
+				removed = append(removed, key)
+			}
+		},
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	for i := 0; i < 5; i++ {
+		cache.Set(i, i)
+	}
+
+	var keyRemoved []RemoveReason
+	cache.OnKeyRemoved(2, false, func(value interface{}, reason RemoveReason) {
+		keyRemoved = append(keyRemoved, reason)
+	})
+
+	calls := 0
+	err := cache.DrainFunc(2, func(entries []Entry) error {
+		calls++
+		if calls == 1 {
+			assert.Empty(t, removed)
+			return nil
+		}
+
+		// Fill the cache while the batch is out
+		for _, key := range []string{"a", "b", "c", "d"} {
+			cache.Set(key, key)
+		}
+		return errors.New("flush failed")
+	})
+
+	assert.EqualError(t, err, "flush failed")
+	assert.Equal(t, []interface{}{0, 1}, removed)
+	assert.Equal(t, []interface{}{2, 3}, evicted)
+	assert.Equal(t, []RemoveReason{ReasonEvicted}, keyRemoved)
+	assert.Equal(t, []interface{}{4, "a", "b", "c", "d"}, cache.OrderedKeys())
+}
+
 func TestRefreshCache(t *testing.T) {
 	cache := New(Config{Capacity: 10})
 	cache.Set("foo", 1)
@@ -346,6 +1937,48 @@ func TestRefreshCache(t *testing.T) {
 
 }
 
+func TestFreeze(t *testing.T) {
+	cache := New(Config{Capacity: 10, MaxAge: time.Hour})
+	cache.Set("foo", 1)
+	cache.Freeze()
+
+	val, ok := cache.Get("foo")
+	assert.True(t, ok)
+	assert.Equal(t, 1, val)
+	assert.True(t, cache.Has("foo"))
+	assert.False(t, cache.Has("bar"))
+
+	val, ok = cache.Peek("foo")
+	assert.True(t, ok)
+	assert.Equal(t, 1, val)
+
+	assert.Equal(t, int64(0), cache.Stats().Gets)
+
+	assert.PanicsWithValue(t, ErrFrozen, func() {
+		cache.Set("bar", 2)
+	})
+	assert.PanicsWithValue(t, ErrFrozen, func() {
+		cache.Remove("foo")
+	})
+	assert.PanicsWithValue(t, ErrFrozen, func() {
+		cache.Clear()
+	})
+	assert.Equal(t, ErrFrozen, cache.Resize(1))
+	assert.Equal(t, ErrFrozen, cache.SetMaxAge(2*time.Hour))
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestFreezeExpired(t *testing.T) {
+	cache := New(Config{Capacity: 10, MaxAge: time.Millisecond})
+	cache.Set("foo", 1)
+	cache.Freeze()
+	<-time.After(time.Millisecond * 2)
+
+	_, ok := cache.Get("foo")
+	assert.False(t, ok)
+	assert.True(t, cache.Has("foo"))
+}
+
 func TestKeys(t *testing.T) {
 	cache := New(Config{Capacity: 10})
 	cache.Set("foo", 1)
@@ -373,6 +2006,43 @@ func TestOrderedKeys(t *testing.T) {
 	assert.Equal(t, "bar", keys[1])
 }
 
+func TestOldestN(t *testing.T) {
+	cache := New(Config{Capacity: 10, MaxAge: time.Millisecond})
+	cache.Set(0, 0)
+	<-time.After(time.Millisecond * 2)
+	for i := 1; i <= 9; i++ {
+		cache.Set(i, i)
+	}
+
+	var keys []interface{}
+	cache.OldestN(3, func(key, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []interface{}{1, 2, 3}, keys)
+
+	keys = nil
+	cache.OldestN(3, func(key, value interface{}) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	assert.Equal(t, []interface{}{1, 2}, keys)
+}
+
+func TestCountFunc(t *testing.T) {
+	cache := New(Config{Capacity: 10, MaxAge: time.Millisecond})
+	cache.Set(0, 0)
+	<-time.After(time.Millisecond * 2)
+	for i := 1; i <= 9; i++ {
+		cache.Set(i, i)
+	}
+
+	count := cache.CountFunc(func(key, value interface{}) bool {
+		return value.(int)%2 == 0
+	})
+	assert.Equal(t, 4, count)
+}
+
 func TestSetMaxAge(t *testing.T) {
 	cache := New(Config{Capacity: 10})
 	err := cache.SetMaxAge(-1 * time.Hour)
@@ -387,40 +2057,281 @@ func TestSetMinAge(t *testing.T) {
 	err := cache.SetMinAge(-1 * time.Hour)
 	assert.Error(t, err)
 
-	err = cache.SetMinAge(time.Second)
-	assert.NoError(t, err)
+	err = cache.SetMinAge(time.Second)
+	assert.NoError(t, err)
+}
+
+func TestOnEviction(t *testing.T) {
+	var eviction bool
+
+	cache := New(Config{Capacity: 1})
+	cache.OnEviction(func(key, value interface{}) {
+		eviction = true
+	})
+
+	cache.Set("foo", 1)
+	cache.Set("bar", 2)
+
+	assert.True(t, eviction)
+}
+
+func TestOnExpiration(t *testing.T) {
+	var expiration bool
+
+	cache := New(Config{
+		Capacity: 1,
+		MaxAge:   time.Millisecond,
+	})
+	cache.OnExpiration(func(key, value interface{}) {
+		expiration = true
+	})
+
+	cache.Set("foo", 1)
+	<-time.After(time.Millisecond * 2)
+	cache.Get("foo")
+
+	assert.True(t, expiration)
+}
+
+func TestForEachLRU(t *testing.T) {
+	cache := New(Config{Capacity: 3})
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Set("c", 3)
+	cache.Get("a")
+
+	var keys []interface{}
+	cache.ForEachLRU(false, func(key, value interface{}, timestamp time.Time) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []interface{}{"b", "c", "a"}, keys)
+
+	keys = nil
+	cache.ForEachLRU(true, func(key, value interface{}, timestamp time.Time) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	assert.Equal(t, []interface{}{"a", "c"}, keys)
+}
+
+func TestByLastAccess(t *testing.T) {
+	cache := New(Config{Capacity: 3, SampleSize: 3})
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, 1)
+		time.Sleep(time.Millisecond)
+	}
+	cache.Get("a")
+
+	var keys []interface{}
+	infos := cache.ByLastAccess()
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	assert.Equal(t, []interface{}{"a", "c", "b"}, keys)
+	assert.Equal(t, 1, infos[0].Value)
+	assert.True(t, infos[0].LastAccess.After(infos[0].Timestamp))
+}
+
+func TestTopByCost(t *testing.T) {
+	cache := New(Config{
+		Capacity: 5,
+		CostFunc: func(key, value interface{}) int64 { return int64(len(value.(string))) },
+	})
+	cache.Set("a", "xx")
+	cache.Set("b", "xxxxx")
+	cache.Set("c", "x")
+	cache.Set("d", "xx")
+
+	var keys []interface{}
+	infos := cache.TopByCost(3)
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	assert.Equal(t, []interface{}{"b", "d", "a"}, keys)
+	assert.Equal(t, int64(5), infos[0].Cost)
+	assert.Len(t, cache.TopByCost(10), 4)
+	assert.Empty(t, cache.TopByCost(0))
+}
+
+func TestFindByValue(t *testing.T) {
+	cache := New(Config{Capacity: 10, MaxAge: time.Hour})
+	for i := 0; i < 6; i++ {
+		cache.Set(i, i)
+	}
+	cache.items[4].Value.(*cacheEntry).timestamp = time.Now().Add(-2 * time.Hour)
+
+	keys := cache.FindByValue(func(value interface{}) bool {
+		return value.(int)%2 == 0
+	})
+	assert.ElementsMatch(t, []interface{}{0, 2}, keys)
+	assert.Equal(t, 6, cache.Len())
+}
+
+func TestUpdateFunc(t *testing.T) {
+	var changes []interface{}
+	cache := New(Config{
+		Capacity: 10,
+		MaxAge:   time.Hour,
+		CostFunc: func(key, value interface{}) int64 { return int64(value.(int)) },
+		OnChange: func(key, old, new interface{}) {
+			changes = append(changes, key)
+		},
+	})
+	for i := 0; i < 6; i++ {
+		cache.Set(i, i)
+	}
+	cache.items[4].Value.(*cacheEntry).timestamp = time.Now().Add(-2 * time.Hour)
+	keys := cache.OrderedKeys()
+	timestamp, _ := cache.ExpiresAt(2)
+
+	updated := cache.UpdateFunc(func(key, value interface{}) bool {
+		return key.(int)%2 == 0
+	}, func(value interface{}) interface{} {
+		return value.(int) * 10
+	})
+
+	assert.Equal(t, 2, updated)
+	assert.Equal(t, []interface{}{2}, changes)
+	value, _ := cache.Peek(2)
+	assert.Equal(t, 20, value)
+	value, _ = cache.Peek(4)
+	assert.Equal(t, 4, value)
+	assert.Equal(t, keys, cache.OrderedKeys())
+	expiresAt, _ := cache.ExpiresAt(2)
+	assert.Equal(t, timestamp, expiresAt)
+	assert.Equal(t, int64(0+1+20+3+4+5), cache.Stats().Cost)
+}
+
+func TestUpdateFuncDeadline(t *testing.T) {
+	cache := New(Config{
+		Capacity: 2,
+		MaxAge:   time.Hour,
+		DeadlineFunc: func(key, value interface{}) time.Time {
+			deadline, _ := value.(time.Time)
+			return deadline
+		},
+	})
+	cache.Set("token", time.Now().Add(time.Minute))
+
+	deadline := time.Now().Add(time.Second)
+	cache.UpdateFunc(func(key, value interface{}) bool {
+		return true
+	}, func(value interface{}) interface{} {
+		return deadline
+	})
+
+	expiresAt, _ := cache.ExpiresAt("token")
+	assert.Equal(t, deadline, expiresAt)
+}
+
+func TestUpdateFuncShouldCache(t *testing.T) {
+	var removed, changes []interface{}
+	cache := New(Config{
+		Capacity: 10,
+		ShouldCache: func(key, value interface{}) bool {
+			return value.(int) < 100
+		},
+		OnRemoval: func(key, value interface{}, reason RemoveReason) {
+			removed = append(removed, key)
+		},
+		OnChange: func(key, old, new interface{}) {
+			changes = append(changes, key)
+		},
+	})
+	cache.Set("a", 1)
+	cache.Set("b", 50)
+	cache.SetWithDeps("c", 2, "b")
+
+	updated := cache.UpdateFunc(func(key, value interface{}) bool {
+		return true
+	}, func(value interface{}) interface{} {
+		return value.(int) * 10
+	})
+
+	assert.Equal(t, 2, updated)
+	assert.ElementsMatch(t, []interface{}{"a", "c"}, changes)
+	assert.Equal(t, []interface{}{"b", "c"}, removed)
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 10, value)
+	assert.False(t, cache.Has("b"))
+	assert.False(t, cache.Has("c"))
+}
+
+func TestFindByType(t *testing.T) {
+	type user struct{ name string }
+
+	cache := New(Config{Capacity: 10})
+	cache.Set("a", &user{"a"})
+	cache.Set("b", "b")
+	cache.Set("c", &user{"c"})
+	cache.Set("d", user{"d"})
+
+	assert.ElementsMatch(t, []interface{}{"a", "c"}, cache.FindByType(&user{}))
+	assert.ElementsMatch(t, []interface{}{"d"}, cache.FindByType(reflect.TypeOf(user{})))
+	assert.ElementsMatch(t, []interface{}{"b"}, cache.FindByType(""))
+}
+
+func TestAgePercentiles(t *testing.T) {
+	cache := New(Config{Capacity: 10, MaxAge: time.Hour})
+	assert.Empty(t, cache.AgePercentiles(50))
+
+	for i := 0; i < 4; i++ {
+		cache.Set(i, i)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	percentiles := cache.AgePercentiles(0, 50, 100)
+	assert.Len(t, percentiles, 3)
+	assert.True(t, percentiles[0] < percentiles[50])
+	assert.True(t, percentiles[50] < percentiles[100])
+	assert.True(t, percentiles[100] >= 40*time.Millisecond)
 }
 
-func TestOnEviction(t *testing.T) {
-	var eviction bool
-
-	cache := New(Config{Capacity: 1})
-	cache.OnEviction(func(key, value interface{}) {
-		eviction = true
-	})
+func TestEntriesSince(t *testing.T) {
+	cache := New(Config{Capacity: 10, MaxAge: time.Hour})
 
 	cache.Set("foo", 1)
+	time.Sleep(time.Millisecond)
+	since := time.Now()
 	cache.Set("bar", 2)
+	cache.Set("baz", 3)
+	cache.Set("foo", 4)
 
-	assert.True(t, eviction)
+	assert.Equal(t, []Entry{{"bar", 2}, {"baz", 3}, {"foo", 4}}, cache.EntriesSince(since))
+	assert.Empty(t, cache.EntriesSince(time.Now().Add(time.Second)))
 }
 
-func TestOnExpiration(t *testing.T) {
-	var expiration bool
+func TestTrimExpired(t *testing.T) {
+	var expired []interface{}
 
 	cache := New(Config{
-		Capacity: 1,
-		MaxAge:   time.Millisecond,
-	})
-	cache.OnExpiration(func(key, value interface{}) {
-		expiration = true
+		Capacity: 10,
+		MaxAge:   50 * time.Millisecond,
+		CostFunc: func(key, value interface{}) int64 {
+			return int64(len(value.(string)))
+		},
+		OnExpiration: func(key, value interface{}) {
+			expired = append(expired, key)
+		},
 	})
 
-	cache.Set("foo", 1)
-	<-time.After(time.Millisecond * 2)
-	cache.Get("foo")
-
-	assert.True(t, expiration)
+	cache.Set("foo", "aaaa")
+	cache.Set("bar", "bb")
+	<-time.After(time.Millisecond * 60)
+	cache.Set("baz", "c")
+
+	entries, bytes := cache.TrimExpired()
+	assert.Equal(t, 2, entries)
+	assert.Equal(t, int64(6), bytes)
+	assert.ElementsMatch(t, []interface{}{"foo", "bar"}, expired)
+	assert.Equal(t, []interface{}{"baz"}, cache.Keys())
+	assert.Equal(t, int64(1), cache.Stats().Cost)
+
+	entries, bytes = cache.TrimExpired()
+	assert.Equal(t, 0, entries)
+	assert.Equal(t, int64(0), bytes)
 }
 
 func TestActiveExpiration(t *testing.T) {
@@ -444,6 +2355,110 @@ func TestActiveExpiration(t *testing.T) {
 	assert.True(t, duration < time.Millisecond*2)
 }
 
+func TestManualSweep(t *testing.T) {
+	var expired []interface{}
+	refreshes := 0
+
+	cache := New(Config{
+		Capacity:        2,
+		MaxAge:          time.Millisecond,
+		ExpirationType:  ActiveExpiration,
+		RefreshInterval: time.Millisecond,
+		OnRefresh: func() map[interface{}]interface{} {
+			refreshes++
+			return nil
+		},
+		ManualSweep: true,
+	})
+	cache.OnExpiration(func(key, value interface{}) {
+		expired = append(expired, key)
+	})
+
+	cache.Set("foo", 1)
+	time.Sleep(5 * time.Millisecond)
+	assert.Empty(t, expired)
+	assert.Equal(t, 1, refreshes)
+
+	cache.Tick()
+	assert.Equal(t, []interface{}{"foo"}, expired)
+	assert.Equal(t, 2, refreshes)
+	assert.Equal(t, 0, cache.Len())
+
+	cache.Freeze()
+	cache.Tick()
+	assert.Equal(t, 2, refreshes)
+}
+
+func TestActiveExpirationBatch(t *testing.T) {
+	invoked := make(chan []Entry, 10)
+	var expiration bool
+
+	cache := New(Config{
+		Capacity:           10,
+		MaxAge:             time.Millisecond,
+		ExpirationType:     ActiveExpiration,
+		ExpirationInterval: 5 * time.Millisecond,
+		OnExpiration: func(key, value interface{}) {
+			expiration = true
+		},
+		OnExpirationBatch: func(entries []Entry) {
+			invoked <- entries
+		},
+	})
+
+	cache.Set("foo", 1)
+	cache.Set("bar", 2)
+
+	var entries []Entry
+	for len(entries) < 2 {
+		entries = append(entries, <-invoked...)
+	}
+	assert.ElementsMatch(t, []Entry{{"foo", 1}, {"bar", 2}}, entries)
+	assert.False(t, expiration)
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestClearDuringActiveExpiration(t *testing.T) {
+	var mutex sync.Mutex
+	removed := make(map[interface{}]int)
+
+	cache := New(Config{
+		Capacity:           1000,
+		MaxAge:             time.Millisecond,
+		ExpirationType:     ActiveExpiration,
+		ExpirationInterval: time.Millisecond,
+		OnRemoval: func(key, value interface{}, reason RemoveReason) {
+			mutex.Lock()
+			removed[value]++
+			mutex.Unlock()
+		},
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5000; i++ {
+			cache.Set(i%100, i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			cache.Clear()
+			cache.TrimExpired()
+		}
+	}()
+	wg.Wait()
+	cache.Clear()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for value, count := range removed {
+		assert.Equal(t, 1, count, "value %v removed more than once", value)
+	}
+}
+
 func TestResize(t *testing.T) {
 	cache := New(Config{
 		Capacity: 2,
@@ -475,12 +2490,280 @@ func TestResize(t *testing.T) {
 	assert.True(t, cache.Has("d"))
 }
 
+func TestReconfigure(t *testing.T) {
+	var evicted []interface{}
+
+	cache := New(Config{Capacity: 3, MaxAge: time.Hour})
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Set("c", 3)
+
+	err := cache.Reconfigure(Config{Capacity: 2, MaxAge: -1})
+	assert.Error(t, err)
+	assert.Equal(t, 3, cache.Len())
+	assert.Equal(t, time.Hour, cache.maxAge)
+
+	err = cache.Reconfigure(Config{
+		Capacity: 2,
+		MaxAge:   2 * time.Hour,
+		MinAge:   time.Hour,
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a"}, evicted)
+	assert.Equal(t, []interface{}{"b", "c"}, cache.OrderedKeys())
+	assert.Equal(t, 2*time.Hour, cache.maxAge)
+	assert.Equal(t, time.Hour, cache.minAge)
+}
+
+func TestReconfigureActiveExpiration(t *testing.T) {
+	invoked := make(chan bool)
+
+	cache := New(Config{Capacity: 1, MaxAge: time.Millisecond})
+	cache.Set("foo", 1)
+
+	err := cache.Reconfigure(Config{
+		Capacity:       1,
+		MaxAge:         time.Millisecond,
+		ExpirationType: ActiveExpiration,
+		OnExpiration: func(key, value interface{}) {
+			invoked <- true
+		},
+	})
+	assert.NoError(t, err)
+
+	<-invoked
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestEvents(t *testing.T) {
+	events := make(chan Event, 2)
+
+	cache := New(Config{Capacity: 1, Name: "users", Events: events})
+	cache.Set("a", 1)
+	time.Sleep(time.Millisecond)
+	cache.Set("b", 2)
+	cache.Remove("b")
+	cache.Set("c", 3)
+	cache.Remove("c") // Dropped, as the channel is full
+
+	event := <-events
+	assert.Equal(t, "users", event.Cache)
+	assert.Equal(t, "a", event.Key)
+	assert.Equal(t, 1, event.Value)
+	assert.Equal(t, ReasonEvicted, event.Reason)
+	assert.True(t, event.Age >= time.Millisecond)
+	assert.False(t, event.LastAccess.IsZero())
+
+	event = <-events
+	assert.Equal(t, "b", event.Key)
+	assert.Equal(t, ReasonRemoved, event.Reason)
+	assert.Empty(t, events)
+}
+
+func TestWithEvictionDisabled(t *testing.T) {
+	var evicted []interface{}
+
+	cache := New(Config{
+		Capacity: 2,
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+
+	cache.WithEvictionDisabled(func() {
+		cache.Set("c", 3)
+		cache.DisableEviction()
+		cache.Set("d", 4)
+		cache.EnableEviction()
+
+		assert.Equal(t, 4, cache.Len())
+		assert.Empty(t, evicted)
+	})
+
+	assert.Equal(t, []interface{}{"a", "b"}, evicted)
+	assert.Equal(t, []interface{}{"c", "d"}, cache.OrderedKeys())
+
+	cache.Set("e", 5)
+	assert.Equal(t, []interface{}{"a", "b", "c"}, evicted)
+}
+
+func TestWithEvictionDisabledGroup(t *testing.T) {
+	var evicted []interface{}
+
+	cache := New(Config{
+		Capacity:    4,
+		MaxPerGroup: 1,
+		GroupFunc: func(key interface{}) string {
+			return key.(string)[:1]
+		},
+		OnEviction: func(key, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	cache.Set("a1", 1)
+	cache.Set("b1", 1)
+
+	cache.WithEvictionDisabled(func() {
+		cache.Set("a2", 2)
+		cache.Set("a3", 3)
+
+		assert.Empty(t, evicted)
+		assert.Equal(t, 3, cache.groups["a"])
+	})
+
+	assert.Equal(t, []interface{}{"a1", "a2"}, evicted)
+	assert.Equal(t, []interface{}{"b1", "a3"}, cache.OrderedKeys())
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, cache.groups)
+}
+
+func TestEnableEvictionFrozen(t *testing.T) {
+	cache := New(Config{Capacity: 1})
+	cache.DisableEviction()
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Freeze()
+
+	cache.EnableEviction()
+	assert.Equal(t, 2, cache.Len())
+}
+
+func TestGrowShrink(t *testing.T) {
+	cache := New(Config{Capacity: 2})
+	cache.Set("a", 1)
+	cache.Set("b", 1)
+
+	assert.NoError(t, cache.Grow(2))
+	cache.Set("c", 1)
+	cache.Set("d", 1)
+	assert.Equal(t, 4, cache.Len())
+	assert.Equal(t, int64(4), cache.Stats().Capacity)
+
+	assert.NoError(t, cache.Shrink(1))
+	assert.Equal(t, []interface{}{"b", "c", "d"}, cache.OrderedKeys())
+
+	assert.Error(t, cache.Grow(0))
+	assert.Error(t, cache.Shrink(3))
+	assert.Equal(t, int64(3), cache.Stats().Capacity)
+}
+
+func TestResizeRemovalReason(t *testing.T) {
+	reasons := map[interface{}]RemoveReason{}
+
+	cache := New(Config{
+		Capacity: 3,
+		OnRemoval: func(key, value interface{}, reason RemoveReason) {
+			reasons[key] = reason
+		},
+	})
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Set("c", 3)
+	cache.Set("d", 4)
+	cache.Resize(2)
+	cache.Remove("d")
+
+	assert.Equal(t, map[interface{}]RemoveReason{
+		"a": ReasonEvicted,
+		"b": ReasonResized,
+		"d": ReasonRemoved,
+	}, reasons)
+}
+
+func TestResizeBelowCapacity(t *testing.T) {
+	cache := New(Config{Capacity: 4})
+	cache.Set("a", 1)
+	cache.Resize(3)
+
+	assert.True(t, cache.Has("a"))
+}
+
+func TestTrimToPercent(t *testing.T) {
+	cache := New(Config{Capacity: 10})
+	for i := 0; i < 8; i++ {
+		cache.Set(i, i)
+	}
+
+	evicted, err := cache.TrimToPercent(75)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, evicted)
+	assert.Equal(t, []interface{}{2, 3, 4, 5, 6, 7}, cache.OrderedKeys())
+
+	_, err = cache.TrimToPercent(101)
+	assert.Error(t, err)
+
+	evicted, err = cache.TrimToPercent(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, evicted)
+	assert.Equal(t, 0, cache.Len())
+}
+
 func TestStats(t *testing.T) {
 	t.Run("reports capacity", func(t *testing.T) {
 		cache := New(Config{Capacity: 100})
 		assert.Equal(t, int64(100), cache.Stats().Capacity)
 	})
 
+	t.Run("reports cost", func(t *testing.T) {
+		cache := New(Config{
+			Capacity: 100,
+			MaxCost:  1000,
+			CostFunc: func(key, value interface{}) int64 {
+				return value.(int64)
+			},
+		})
+		cache.Set("a", int64(10))
+		cache.Set("b", int64(20))
+
+		stats := cache.Stats()
+		assert.Equal(t, int64(30), stats.Cost)
+		assert.Equal(t, int64(1000), stats.MaxCost)
+	})
+
+	t.Run("reports eviction ages", func(t *testing.T) {
+		cache := New(Config{Capacity: 2})
+		cache.Set("a", 1)
+		time.Sleep(10 * time.Millisecond)
+		cache.Set("b", 1)
+		cache.Set("c", 1)
+		cache.Set("d", 1)
+		cache.Resize(1)
+
+		stats := cache.Stats()
+		assert.Equal(t, int64(3), stats.Evictions)
+		assert.True(t, stats.MaxEvictionAge >= 10*time.Millisecond)
+		assert.True(t, stats.MinEvictionAge < stats.MaxEvictionAge)
+		assert.True(t, stats.AvgEvictionAge > stats.MinEvictionAge)
+		assert.True(t, stats.AvgEvictionAge < stats.MaxEvictionAge)
+	})
+
+	t.Run("observes delta", func(t *testing.T) {
+		cache := New(Config{Capacity: 100})
+		cache.Set("a", 1)
+
+		var prev, delta Stats
+		cache.ObserveDelta(&prev, &delta)
+		assert.Equal(t, int64(1), delta.Sets)
+
+		cache.Set("b", 1)
+		cache.Get("b")
+		cache.ObserveDelta(&prev, &delta)
+		assert.Equal(t, int64(1), delta.Sets)
+		assert.Equal(t, int64(1), delta.Hits)
+		assert.Equal(t, int64(2), delta.Count)
+		assert.Equal(t, cache.Stats(), prev)
+	})
+
+	t.Run("reports name", func(t *testing.T) {
+		cache := New(Config{Capacity: 100, Name: "users"})
+		assert.Equal(t, "users", cache.Stats().Name)
+		assert.Equal(t, "users", cache.Stats().Delta(Stats{}).Name)
+	})
+
 	t.Run("reports count", func(t *testing.T) {
 		cache := New(Config{Capacity: 100})
 		for i := 0; i < 10; i++ {
@@ -497,6 +2780,20 @@ func TestStats(t *testing.T) {
 		assert.Equal(t, int64(10), cache.Stats().Sets)
 	})
 
+	t.Run("counts inserts and overwrites", func(t *testing.T) {
+		cache := New(Config{Capacity: 2, MaxAge: time.Second})
+		cache.Set("foo", 1)
+		cache.Set("foo", 2)
+		cache.Set("bar", 3)
+		cache.Set("baz", 4)
+		cache.Set("foo", 5)
+
+		stats := cache.Stats()
+		assert.Equal(t, int64(4), stats.Inserts)
+		assert.Equal(t, int64(1), stats.Overwrites)
+		assert.Equal(t, stats.Sets, stats.Inserts+stats.Overwrites)
+	})
+
 	t.Run("increments gets", func(t *testing.T) {
 		cache := New(Config{Capacity: 100, MaxAge: time.Second})
 		for i := 0; i < 10; i++ {
@@ -549,6 +2846,16 @@ func TestStats(t *testing.T) {
 		}
 	})
 
+	t.Run("disabled", func(t *testing.T) {
+		cache := New(Config{Capacity: 1, DisableStats: true})
+		cache.Set("a", 1)
+		cache.Set("b", 2)
+		cache.Get("a")
+		cache.Get("b")
+
+		assert.Equal(t, Stats{Capacity: 1, Count: 1}, cache.Stats())
+	})
+
 	t.Run("copy", func(t *testing.T) {
 		cache := New(Config{Capacity: 100, MaxAge: time.Second})
 		stats := cache.Stats()
@@ -559,6 +2866,45 @@ func TestStats(t *testing.T) {
 	})
 }
 
+func TestStatsExtended(t *testing.T) {
+	cache := New(Config{Capacity: 4, Name: "users"})
+	assert.Equal(t, StatsExtended{Stats: Stats{Capacity: 4, Name: "users"}}, cache.StatsExtended())
+
+	for i := 0; i < 5; i++ {
+		cache.Set(i, i)
+	}
+	time.Sleep(time.Millisecond)
+	cache.Get(4)
+	cache.Get(0)
+
+	stats := cache.StatsExtended()
+	assert.Equal(t, 0.5, stats.HitRatio)
+	assert.Equal(t, 1.0, stats.FillRatio)
+	assert.Equal(t, 0.2, stats.EvictionsPerSet)
+	assert.True(t, stats.OldestAge >= time.Millisecond)
+
+	data, err := cache.StatsJSON()
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"name":"users"`)
+	assert.Contains(t, string(data), `"hit_ratio":0.5`)
+	assert.Contains(t, string(data), `"evictions_per_set":0.2`)
+}
+
+func TestLockStats(t *testing.T) {
+	cache := New(Config{Capacity: 10})
+	cache.Set("foo", 1)
+	assert.Equal(t, LockStats{}, cache.LockStats())
+
+	cache = New(Config{Capacity: 10, TrackLockHold: true})
+	cache.Set("foo", 1)
+	cache.Get("foo")
+	cache.Remove("foo")
+
+	stats := cache.LockStats()
+	assert.Equal(t, int64(3), stats.Acquisitions)
+	assert.True(t, stats.MaxLockHold >= stats.AvgLockHold)
+}
+
 func BenchmarkCache(b *testing.B) {
 	cache := New(Config{Capacity: 100, MaxAge: time.Second})
 
@@ -569,3 +2915,124 @@ func BenchmarkCache(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkCacheStatsDisabled(b *testing.B) {
+	cache := New(Config{Capacity: 100, MaxAge: time.Second, DisableStats: true})
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cache.Set("a", "b")
+			cache.Get("a")
+		}
+	})
+}
+
+func BenchmarkCacheObserveDelta(b *testing.B) {
+	cache := New(Config{Capacity: 100})
+	var prev, delta Stats
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cache.ObserveDelta(&prev, &delta)
+	}
+}
+
+func BenchmarkCacheEviction(b *testing.B) {
+	benchmarkEviction(b, New(Config{Capacity: 1000}))
+}
+
+func BenchmarkCacheSampledEviction(b *testing.B) {
+	benchmarkEviction(b, New(Config{Capacity: 1000, SampleSize: 5}))
+}
+
+func BenchmarkCacheChurn(b *testing.B) {
+	cache := New(Config{Capacity: 1000})
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Set(1000+i, nil)
+	}
+}
+
+func BenchmarkCacheCostEviction(b *testing.B) {
+	for _, policy := range []struct {
+		name   string
+		policy EvictionPolicy
+	}{
+		{"LRU", LRUEviction},
+		{"CostAware", CostAwareEviction},
+	} {
+		b.Run(policy.name, func(b *testing.B) {
+			cache := New(Config{
+				Capacity:       1000,
+				SampleSize:     5,
+				EvictionPolicy: policy.policy,
+				CostFunc: func(key, value interface{}) int64 {
+					return value.(int64)
+				},
+			})
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.Set(i, int64(1+i%97*13))
+			}
+
+			stats := cache.Stats()
+			b.ReportMetric(float64(stats.Cost)/float64(stats.Count), "bytes/item")
+		})
+	}
+}
+
+func BenchmarkCacheSweepEmpty(b *testing.B) {
+	cache := New(Config{Capacity: 100, MaxAge: time.Hour})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cache.TrimExpired()
+	}
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	benchmarkGet(b, New(Config{Capacity: 1000}))
+}
+
+func BenchmarkCacheSampledGet(b *testing.B) {
+	benchmarkGet(b, New(Config{Capacity: 1000, SampleSize: 5}))
+}
+
+func benchmarkGet(b *testing.B, cache *Cache) {
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Get(i % 1000)
+			i++
+		}
+	})
+}
+
+func benchmarkEviction(b *testing.B, cache *Cache) {
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Get(i % 1000)
+			if i%10 == 0 {
+				cache.Set(1000+i, i)
+			}
+			i++
+		}
+	})
+}
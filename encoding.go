@@ -0,0 +1,144 @@
+package agecache
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"time"
+)
+
+// record is the serialized form of an item written by WriteTo.
+type record struct {
+	Key       interface{}
+	Value     interface{}
+	Timestamp time.Time
+	MaxAge    time.Duration
+	CreatedAt time.Time
+}
+
+// writeBatchSize is the number of items WriteTo copies under the read lock at
+// a time, before encoding them without it.
+const writeBatchSize = 256
+
+// WriteTo writes the unexpired items in the cache to w as a stream of gob
+// encoded records, from least to most recently used, for LoadFrom to read.
+// Concrete key and value types other than gob's predeclared types must be
+// registered with gob.Register. Only the keys are copied up front; the items
+// are then copied in batches under the read lock and encoded without it, so
+// items set meanwhile may be omitted, and items removed meanwhile are skipped.
+// Returns the number of bytes written.
+func (cache *Cache) WriteTo(w io.Writer) (int64, error) {
+	cache.mutex.RLock()
+	keys := make([]interface{}, 0, cache.evictionList.Len())
+	for element := cache.evictionList.Back(); element != nil; element = element.Prev() {
+		keys = append(keys, element.Value.(*cacheEntry).key)
+	}
+	cache.mutex.RUnlock()
+
+	counter := &countingWriter{w: w}
+	encoder := gob.NewEncoder(counter)
+	batch := make([]record, 0, writeBatchSize)
+	for len(keys) > 0 {
+		n := writeBatchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		batch = cache.records(batch[:0], keys[:n])
+		keys = keys[n:]
+
+		for i := range batch {
+			if err := encoder.Encode(&batch[i]); err != nil {
+				return counter.n, err
+			}
+		}
+	}
+
+	return counter.n, nil
+}
+
+// records appends the records of the unexpired items at keys to batch, under
+// the read lock.
+func (cache *Cache) records(batch []record, keys []interface{}) []record {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	for _, key := range keys {
+		element, ok := cache.items[key]
+		if !ok {
+			continue
+		}
+
+		entry := element.Value.(*cacheEntry)
+		if cache.expired(entry) {
+			continue
+		}
+
+		batch = append(batch, record{
+			Key:       entry.key,
+			Value:     entry.value,
+			Timestamp: entry.timestamp,
+			MaxAge:    cache.maxAge,
+			CreatedAt: entry.createdAt,
+		})
+	}
+	return batch
+}
+
+// LoadFrom reads items written by WriteTo from r until EOF, setting each as
+// Set would, so that the most recently used items are kept if they exceed the
+// capacity. Items keep their remaining time to live and creation time, and
+// those already expired, or past this cache's MaxLifetime, are dropped. Records are read one at a time, and the lock is not held while
+// reading. Returns ErrFrozen if the cache is frozen.
+func (cache *Cache) LoadFrom(r io.Reader) error {
+	decoder := gob.NewDecoder(r)
+	for {
+		var rec record
+		if err := decoder.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if err := cache.load(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func (cache *Cache) load(rec record) error {
+	cache.lock()
+	defer cache.unlock()
+
+	if cache.frozen.Load() {
+		return ErrFrozen
+	}
+
+	if rec.MaxAge > 0 && time.Since(rec.Timestamp) > rec.MaxAge {
+		return nil
+	}
+	if cache.maxLifetime > 0 && !rec.CreatedAt.IsZero() && time.Since(rec.CreatedAt) > cache.maxLifetime {
+		return nil
+	}
+
+	key := cache.canonical(rec.Key)
+	if !cache.admit(key, rec.Value) {
+		return nil
+	}
+
+	cache.countSet(key)
+	cache.insert(key, rec.Value, cache.rebase(rec.Timestamp, rec.MaxAge), rec.CreatedAt, false)
+	return nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,115 @@
+package agecache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteToLoadFrom(t *testing.T) {
+	src := New(Config{Capacity: 10, MaxAge: time.Hour})
+	src.Set("foo", 1)
+	src.Set("bar", 2)
+	src.Set("baz", 3)
+	src.Get("foo")
+
+	var buf bytes.Buffer
+	n, err := src.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	dst := New(Config{Capacity: 2, MaxAge: 2 * time.Hour})
+	assert.NoError(t, dst.LoadFrom(&buf))
+	assert.Equal(t, []interface{}{"baz", "foo"}, dst.OrderedKeys())
+
+	expected, _ := src.ExpiresAt("foo")
+	expiresAt, ok := dst.ExpiresAt("foo")
+	assert.True(t, ok)
+	assert.WithinDuration(t, expected, expiresAt, time.Millisecond)
+}
+
+func TestLoadFromExpired(t *testing.T) {
+	src := New(Config{Capacity: 10, MaxAge: 5 * time.Millisecond})
+	src.Set("foo", 1)
+
+	var buf bytes.Buffer
+	_, err := src.WriteTo(&buf)
+	assert.NoError(t, err)
+	<-time.After(10 * time.Millisecond)
+
+	dst := New(Config{Capacity: 10})
+	assert.NoError(t, dst.LoadFrom(&buf))
+	assert.Equal(t, 0, dst.Len())
+}
+
+func TestLoadFromPreservesLifetime(t *testing.T) {
+	src := New(Config{Capacity: 10})
+	src.Set("foo", 1)
+	createdAt, _ := src.CreatedAt("foo")
+	<-time.After(10 * time.Millisecond)
+	src.Set("bar", 2)
+
+	var buf bytes.Buffer
+	_, err := src.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	dst := New(Config{Capacity: 10, MaxLifetime: 5 * time.Millisecond})
+	assert.NoError(t, dst.LoadFrom(&buf))
+	assert.Equal(t, []interface{}{"bar"}, dst.OrderedKeys())
+
+	buf.Reset()
+	_, err = src.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	dst = New(Config{Capacity: 10})
+	assert.NoError(t, dst.LoadFrom(&buf))
+	loaded, _ := dst.CreatedAt("foo")
+	assert.True(t, createdAt.Equal(loaded))
+}
+
+func TestLoadFromFrozen(t *testing.T) {
+	src := New(Config{Capacity: 10})
+	src.Set("foo", 1)
+
+	var buf bytes.Buffer
+	_, err := src.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	dst := New(Config{Capacity: 10})
+	dst.Freeze()
+	assert.Equal(t, ErrFrozen, dst.LoadFrom(&buf))
+}
+
+// writerFunc calls fn before each write to the buffer.
+type writerFunc struct {
+	bytes.Buffer
+	fn func()
+}
+
+func (w *writerFunc) Write(p []byte) (int, error) {
+	w.fn()
+	return w.Buffer.Write(p)
+}
+
+func TestWriteToUnlocked(t *testing.T) {
+	src := New(Config{Capacity: 1000})
+	for i := 0; i < 2*writeBatchSize; i++ {
+		src.Set(i, i)
+	}
+
+	// The cache may be written to, and the removed items are skipped
+	w := &writerFunc{fn: func() {
+		src.Remove(2*writeBatchSize - 1)
+		src.Set(-1, -1)
+	}}
+	_, err := src.WriteTo(w)
+	assert.NoError(t, err)
+
+	dst := New(Config{Capacity: 1000})
+	assert.NoError(t, dst.LoadFrom(&w.Buffer))
+	assert.Equal(t, 2*writeBatchSize-1, dst.Len())
+	assert.False(t, dst.Has(-1))
+	assert.False(t, dst.Has(2*writeBatchSize-1))
+}
@@ -0,0 +1,73 @@
+package agecache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// HashKey returns a composite key built from the parts, for caches keyed by
+// strings. Each part is encoded along with its type and length before the
+// encoding is hashed with SHA-256, so that parts which concatenate to the same
+// string, e.g. "a:bc" and "ab:c", or values of different types with the same
+// representation, e.g. 1 and "1", or int16(1) and int32(1), yield different
+// keys. Parts of kinds other than strings, byte slices, integers, floats and
+// bools are encoded with fmt's %#v, so they should not hold pointers, whose
+// addresses differ between processes. Parts are encoded into a buffer on the
+// stack where they fit, so only the returned key is allocated.
+func HashKey(parts ...interface{}) string {
+	var scratch [256]byte
+	buf := scratch[:0]
+	for _, part := range parts {
+		buf = appendPart(buf, part)
+	}
+
+	sum := sha256.Sum256(buf)
+	var key [2 * sha256.Size]byte
+	hex.Encode(key[:], sum[:])
+	return string(key[:])
+}
+
+// appendPart appends the type of the part and its value to buf, each preceded
+// by its length.
+func appendPart(buf []byte, part interface{}) []byte {
+	typeName := "nil"
+	if t := reflect.TypeOf(part); t != nil {
+		typeName = t.String()
+	}
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(typeName)))
+	buf = append(buf, typeName...)
+
+	// The length of the value is filled in once it is appended
+	start := len(buf)
+	buf = append(buf, make([]byte, 8)...)
+
+	switch v := reflect.ValueOf(part); v.Kind() {
+	case reflect.String:
+		buf = append(buf, v.String()...)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf = append(buf, v.Bytes()...)
+		} else {
+			buf = fmt.Appendf(buf, "%#v", part)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf = strconv.AppendInt(buf, v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf = strconv.AppendUint(buf, v.Uint(), 10)
+	case reflect.Float32:
+		buf = strconv.AppendFloat(buf, v.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		buf = strconv.AppendFloat(buf, v.Float(), 'g', -1, 64)
+	case reflect.Bool:
+		buf = strconv.AppendBool(buf, v.Bool())
+	default:
+		buf = fmt.Appendf(buf, "%#v", part)
+	}
+
+	binary.BigEndian.PutUint64(buf[start:], uint64(len(buf)-start-8))
+	return buf
+}
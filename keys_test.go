@@ -0,0 +1,42 @@
+package agecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashKey(t *testing.T) {
+	assert.Equal(t, HashKey("user", 42), HashKey("user", 42))
+	assert.Len(t, HashKey(), 64)
+
+	ambiguous := [][2][]interface{}{
+		{{"a:bc"}, {"ab:c"}},
+		{{"a", "bc"}, {"ab", "c"}},
+		{{"a:", "b"}, {"a", ":b"}},
+		{{1}, {"1"}},
+		{{1}, {int64(1)}},
+		{{"", "a"}, {"a", ""}},
+		{{"a"}, {"a", ""}},
+		{{true}, {"true"}},
+		{{[]byte("a")}, {"a"}},
+		{{struct{ A string }{"a"}}, {struct{ B string }{"a"}}},
+		{{int16(1)}, {int32(1)}},
+		{{uint8(1)}, {1}},
+		{{float32(1.5)}, {1.5}},
+		{{1.5}, {"1.5"}},
+		{{time.Duration(1)}, {int64(1)}},
+		{{nil}, {"<nil>"}},
+	}
+	for _, pair := range ambiguous {
+		assert.NotEqual(t, HashKey(pair[0]...), HashKey(pair[1]...), "%v and %v", pair[0], pair[1])
+	}
+}
+
+func BenchmarkHashKey(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		HashKey("user", int64(i), true, 1.5, []byte("profile"))
+	}
+}
@@ -0,0 +1,125 @@
+package agecache
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// MemoryPressureController is a coarse, process wide backstop against running
+// out of memory. When the heap in use, as reported by runtime.ReadMemStats,
+// exceeds a threshold, it sheds items from each registered cache with
+// TrimToPercent. It does not account for the memory of individual items, so
+// caches are trimmed in proportion to their length rather than their size.
+// As the heap only shrinks once the trimmed items are garbage collected, the
+// caches are not trimmed again until a collection has completed.
+type MemoryPressureController struct {
+	mutex     sync.Mutex
+	caches    map[*Cache]struct{}
+	maxHeap   uint64
+	percent   float64
+	readHeap  func() (heap uint64, gcs uint32)
+	trimmed   bool
+	trimmedGC uint32 // Number of collections completed at the last trim
+	stop      chan struct{}
+}
+
+// NewMemoryPressureController returns a controller that trims the registered
+// caches to percent of their items whenever the heap in use exceeds maxHeap
+// bytes. If interval is positive, the heap is checked at that interval until
+// Stop is called; otherwise, it is only checked by calls to Check. Panics
+// given a zero maxHeap or a percent that is not between 0 and 100.
+func NewMemoryPressureController(maxHeap uint64, percent float64, interval time.Duration) *MemoryPressureController {
+	if maxHeap == 0 {
+		panic("Must supply a positive maxHeap")
+	} else if percent < 0 || percent > 100 {
+		panic("Must supply a percent between 0 and 100")
+	}
+
+	controller := &MemoryPressureController{
+		caches:   make(map[*Cache]struct{}),
+		maxHeap:  maxHeap,
+		percent:  percent,
+		readHeap: readHeapAlloc,
+		stop:     make(chan struct{}),
+	}
+
+	if interval > 0 {
+		go func() {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					controller.Check()
+				case <-controller.stop:
+					return
+				}
+			}
+		}()
+	}
+
+	return controller
+}
+
+// Register adds the cache to those trimmed under memory pressure.
+func (controller *MemoryPressureController) Register(cache *Cache) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	controller.caches[cache] = struct{}{}
+}
+
+// Unregister removes the cache from those trimmed under memory pressure, so
+// that it may be garbage collected.
+func (controller *MemoryPressureController) Unregister(cache *Cache) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	delete(controller.caches, cache)
+}
+
+// Check reads the heap in use and, if it exceeds the threshold, trims each
+// registered cache. Frozen caches are skipped. The caches are not trimmed if no
+// garbage collection has completed since they were last trimmed, as the heap
+// read then still includes the items already shed. Returns whether or not the
+// caches were trimmed.
+func (controller *MemoryPressureController) Check() bool {
+	heap, gcs := controller.readHeap()
+	if heap <= controller.maxHeap {
+		return false
+	}
+
+	controller.mutex.Lock()
+	if controller.trimmed && gcs == controller.trimmedGC {
+		controller.mutex.Unlock()
+		return false
+	}
+	controller.trimmed, controller.trimmedGC = true, gcs
+
+	caches := make([]*Cache, 0, len(controller.caches))
+	for cache := range controller.caches {
+		caches = append(caches, cache)
+	}
+	controller.mutex.Unlock()
+
+	for _, cache := range caches {
+		// Frozen caches are left as is
+		cache.TrimToPercent(controller.percent)
+	}
+	return true
+}
+
+// Stop stops the background check, if any. It must not be called more than
+// once.
+func (controller *MemoryPressureController) Stop() {
+	close(controller.stop)
+}
+
+// readHeapAlloc returns the bytes of allocated heap objects, and the number of
+// garbage collections completed.
+func readHeapAlloc() (uint64, uint32) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc, stats.NumGC
+}
@@ -0,0 +1,45 @@
+package agecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryPressureController(t *testing.T) {
+	controller := NewMemoryPressureController(1000, 50, 0)
+	defer controller.Stop()
+
+	heap, gcs := uint64(1000), uint32(0)
+	controller.readHeap = func() (uint64, uint32) { return heap, gcs }
+
+	cache := New(Config{Capacity: 10})
+	for i := 0; i < 4; i++ {
+		cache.Set(i, i)
+	}
+	controller.Register(cache)
+
+	assert.False(t, controller.Check())
+	assert.Equal(t, 4, cache.Len())
+
+	heap = 1001
+	assert.True(t, controller.Check())
+	assert.Equal(t, []interface{}{2, 3}, cache.OrderedKeys())
+
+	// The heap read is stale until the next collection
+	assert.False(t, controller.Check())
+	assert.Equal(t, 2, cache.Len())
+
+	gcs++
+	assert.True(t, controller.Check())
+	assert.Equal(t, []interface{}{3}, cache.OrderedKeys())
+
+	gcs++
+	controller.Unregister(cache)
+	assert.True(t, controller.Check())
+	assert.Equal(t, 1, cache.Len())
+
+	assert.Panics(t, func() { NewMemoryPressureController(0, 50, time.Second) })
+	assert.Panics(t, func() { NewMemoryPressureController(1000, 150, time.Second) })
+}
@@ -0,0 +1,73 @@
+package agecache
+
+import "time"
+
+// Option configures a cache constructed by New.
+type Option interface {
+	apply(config *Config)
+}
+
+type optionFunc func(config *Config)
+
+func (fn optionFunc) apply(config *Config) {
+	fn(config)
+}
+
+// apply replaces the configuration built so far with the Config, allowing it
+// to be passed to New as an Option. Options following it refine it further.
+func (config Config) apply(c *Config) {
+	*c = config
+}
+
+// WithName sets the name of the cache, reported by Name and in Stats.
+func WithName(name string) Option {
+	return optionFunc(func(config *Config) {
+		config.Name = name
+	})
+}
+
+// WithCapacity sets the maximum number of items in the cache.
+func WithCapacity(capacity int) Option {
+	return optionFunc(func(config *Config) {
+		config.Capacity = capacity
+	})
+}
+
+// WithMaxAge sets the max duration before an item expires.
+func WithMaxAge(maxAge time.Duration) Option {
+	return optionFunc(func(config *Config) {
+		config.MaxAge = maxAge
+	})
+}
+
+// WithMinAge sets the min duration before an item expires, enabling jitter
+// when less than the max age.
+func WithMinAge(minAge time.Duration) Option {
+	return optionFunc(func(config *Config) {
+		config.MinAge = minAge
+	})
+}
+
+// WithActiveExpiration enables active expiration, iterating over the keyspace
+// at the given interval. An interval of zero defaults to the max age.
+func WithActiveExpiration(interval time.Duration) Option {
+	return optionFunc(func(config *Config) {
+		config.ExpirationType = ActiveExpiration
+		config.ExpirationInterval = interval
+	})
+}
+
+// WithOnEviction sets the callback invoked when an item is evicted due to the
+// LRU policy.
+func WithOnEviction(callback func(key, value interface{})) Option {
+	return optionFunc(func(config *Config) {
+		config.OnEviction = callback
+	})
+}
+
+// WithOnExpiration sets the callback invoked when an item expired.
+func WithOnExpiration(callback func(key, value interface{})) Option {
+	return optionFunc(func(config *Config) {
+		config.OnExpiration = callback
+	})
+}
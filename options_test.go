@@ -0,0 +1,61 @@
+package agecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions(t *testing.T) {
+	var evicted interface{}
+
+	cache := New(
+		WithCapacity(1),
+		WithMaxAge(time.Hour),
+		WithMinAge(time.Minute),
+		WithName("users"),
+		WithOnEviction(func(key, value interface{}) {
+			evicted = key
+		}),
+	)
+
+	assert.Equal(t, 1, cache.capacity)
+	assert.Equal(t, time.Hour, cache.maxAge)
+	assert.Equal(t, time.Minute, cache.minAge)
+	assert.Equal(t, "users", cache.Name())
+
+	cache.Set("foo", 1)
+	cache.Set("bar", 2)
+	assert.Equal(t, "foo", evicted)
+}
+
+func TestOptionsRefineConfig(t *testing.T) {
+	cache := New(Config{Capacity: 1, MaxAge: time.Hour}, WithCapacity(10))
+
+	assert.Equal(t, 10, cache.capacity)
+	assert.Equal(t, time.Hour, cache.maxAge)
+}
+
+func TestOptionsActiveExpiration(t *testing.T) {
+	invoked := make(chan bool)
+
+	cache := New(
+		WithCapacity(1),
+		WithMaxAge(time.Millisecond),
+		WithActiveExpiration(time.Millisecond),
+		WithOnExpiration(func(key, value interface{}) {
+			invoked <- true
+		}),
+	)
+	cache.Set("foo", 1)
+
+	<-invoked
+	assert.Equal(t, ActiveExpiration, cache.expirationType)
+}
+
+func TestOptionsInvalid(t *testing.T) {
+	assert.Panics(t, func() {
+		New(WithCapacity(1), WithMaxAge(-1*time.Hour))
+	})
+}
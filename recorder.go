@@ -0,0 +1,107 @@
+package agecache
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Operations logged by a Recorder.
+const (
+	opSet    = "set"
+	opGet    = "get"
+	opRemove = "remove"
+	opRename = "rename" // Value holds the new key
+	opUpdate = "update" // The value is replaced in place, as by UpdateFunc
+)
+
+// operation is the serialized form of an operation logged by a Recorder.
+type operation struct {
+	Op    string
+	Key   interface{}
+	Value interface{}
+	Time  time.Time
+}
+
+// Recorder logs the operations of the caches it is configured on to an
+// io.Writer as a stream of gob encoded records, for Replay to read. Every item
+// set, read, removed, renamed or updated in place is logged, whichever method
+// or background task did so, including evictions and expirations as removals.
+// Keys are logged as returned by KeyFunc, which must then return them
+// unchanged for Replay to reproduce the cache. Concrete key and value types
+// other than gob's predeclared types must be registered with gob.Register.
+// Operations are logged in the order they are applied, under the cache's lock.
+type Recorder struct {
+	mutex   sync.Mutex
+	encoder *gob.Encoder
+	err     error
+}
+
+// NewRecorder returns a Recorder logging operations to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{encoder: gob.NewEncoder(w)}
+}
+
+// Err returns the first error encountered writing operations, after which the
+// Recorder stops logging.
+func (recorder *Recorder) Err() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return recorder.err
+}
+
+func (recorder *Recorder) record(op string, key, value interface{}) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if recorder.err != nil {
+		return
+	}
+
+	recorder.err = recorder.encoder.Encode(operation{
+		Op:    op,
+		Key:   key,
+		Value: value,
+		Time:  time.Now(),
+	})
+}
+
+// Replay applies the operations logged by a Recorder from r to the cache, in
+// order, until EOF. Operations are applied immediately rather than at their
+// recorded times, so items only expire during the replay if they would
+// without the delays between operations.
+func Replay(r io.Reader, cache *Cache) error {
+	decoder := gob.NewDecoder(r)
+	for {
+		var op operation
+		if err := decoder.Decode(&op); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		switch op.Op {
+		case opSet:
+			cache.Set(op.Key, op.Value)
+		case opGet:
+			cache.Get(op.Key)
+		case opRemove:
+			cache.Remove(op.Key)
+		case opRename:
+			cache.Rename(op.Key, op.Value)
+		case opUpdate:
+			cache.UpdateFunc(func(key, value interface{}) bool {
+				return key == op.Key
+			}, func(interface{}) interface{} {
+				return op.Value
+			})
+		default:
+			return fmt.Errorf("Unknown recorded operation %q", op.Op)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package agecache
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorderReplay(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+
+	cache := New(Config{Capacity: 2, Recorder: recorder})
+	cache.Set("foo", 1)
+	cache.Set("bar", 2)
+	cache.Get("foo")
+	cache.Set("baz", 3)
+	cache.Remove("foo")
+	assert.NoError(t, recorder.Err())
+
+	replayed := New(Config{Capacity: 2})
+	assert.NoError(t, Replay(&buf, replayed))
+	assert.Equal(t, cache.OrderedKeys(), replayed.OrderedKeys())
+	stats, replayedStats := cache.Stats(), replayed.Stats()
+	assert.Equal(t, stats.Sets, replayedStats.Sets)
+	assert.Equal(t, stats.Hits, replayedStats.Hits)
+	assert.Equal(t, stats.Evictions, replayedStats.Evictions)
+}
+
+func TestRecorderReplayAllOperations(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+
+	cache := New(Config{Capacity: 3, Recorder: recorder})
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Set("c", 3)
+	cache.GetVersioned("a")
+	cache.GetWithFreshness("b")
+	cache.Rename("c", "d")
+	cache.UpdateFunc(func(key, value interface{}) bool {
+		return key == "b"
+	}, func(value interface{}) interface{} {
+		return 20
+	})
+	cache.EvictOldest()
+	cache.Set("e", 5)
+	cache.RemoveMany([]interface{}{"d"})
+	cache.IncrementOrSet("f", 1, 6)
+	assert.NoError(t, recorder.Err())
+
+	replayed := New(Config{Capacity: 3})
+	assert.NoError(t, Replay(bytes.NewReader(buf.Bytes()), replayed))
+	assert.Equal(t, []interface{}{"b", "e", "f"}, replayed.OrderedKeys())
+	assert.Equal(t, cache.OrderedKeys(), replayed.OrderedKeys())
+	for _, key := range cache.Keys() {
+		expected, _ := cache.Peek(key)
+		value, _ := replayed.Peek(key)
+		assert.EqualValues(t, expected, value)
+	}
+
+	cache.Clear()
+	replayed = New(Config{Capacity: 3})
+	assert.NoError(t, Replay(&buf, replayed))
+	assert.Empty(t, replayed.Keys())
+}
@@ -0,0 +1,95 @@
+package agecache
+
+import "time"
+
+// WindowedStats returns the stats of the cache over the last window, with a
+// granularity of one second, e.g. for alerting on the recent hit ratio, which
+// the cumulative counters hide. Counters are calculated as the difference
+// since the start of the window, as Delta would, and gauges are current. The
+// window is capped at config.StatsWindow, and counters are zero if it is not
+// configured.
+func (cache *Cache) WindowedStats(window time.Duration) Stats {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	current := cache.stats()
+	if cache.window == nil {
+		return current.Delta(current)
+	}
+	return current.Delta(cache.window.since(time.Now(), window, current))
+}
+
+// observeWindow snapshots the stats counters on the first counted operation of
+// each second, before it is counted, if config.StatsWindow is set. The caller
+// must hold the write lock.
+func (cache *Cache) observeWindow() {
+	if cache.window == nil {
+		return
+	}
+
+	if snapshot := cache.window.claim(time.Now()); snapshot != nil {
+		snapshot.stats = cache.stats()
+	}
+}
+
+// statsWindow is a ring buffer of snapshots of the cumulative stats, indexed by
+// the second in which each was taken.
+type statsWindow struct {
+	snapshots []statsSnapshot
+}
+
+// statsSnapshot holds the stats at the start of the second, as of the first
+// operation counted in it.
+type statsSnapshot struct {
+	second int64
+	stats  Stats
+}
+
+// newStatsWindow returns a statsWindow retaining enough snapshots to report on
+// window, rounded up to the second.
+func newStatsWindow(window time.Duration) *statsWindow {
+	seconds := int((window + time.Second - 1) / time.Second)
+	return &statsWindow{snapshots: make([]statsSnapshot, seconds+1)}
+}
+
+// claim returns the snapshot for the second of now, to be filled in by the
+// caller, or nil if it was already taken.
+func (w *statsWindow) claim(now time.Time) *statsSnapshot {
+	second := now.Unix()
+	snapshot := &w.snapshots[second%int64(len(w.snapshots))]
+	if snapshot.second == second {
+		return nil
+	}
+
+	*snapshot = statsSnapshot{second: second}
+	return snapshot
+}
+
+// since returns the stats at the start of the window ending at now, or current
+// if nothing was counted since.
+func (w *statsWindow) since(now time.Time, window time.Duration, current Stats) Stats {
+	if window <= 0 {
+		return current
+	}
+
+	seconds := int64((window + time.Second - 1) / time.Second)
+	if max := int64(len(w.snapshots) - 1); seconds > max {
+		seconds = max
+	}
+
+	// Seconds without a snapshot counted nothing, so the earliest snapshot in
+	// the window holds the stats at its start
+	start := now.Unix() - seconds + 1
+	var earliest *statsSnapshot
+	for i := range w.snapshots {
+		snapshot := &w.snapshots[i]
+		if snapshot.second >= start && (earliest == nil || snapshot.second < earliest.second) {
+			earliest = snapshot
+		}
+	}
+
+	if earliest == nil {
+		return current
+	}
+	return earliest.stats
+}
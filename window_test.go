@@ -0,0 +1,47 @@
+package agecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowedStats(t *testing.T) {
+	cache := New(Config{Capacity: 1, StatsWindow: time.Minute})
+	cache.Set("foo", 1)
+	cache.Get("foo")
+	cache.Get("bar")
+	cache.Set("bar", 2)
+
+	stats := cache.WindowedStats(time.Hour)
+	assert.Equal(t, int64(2), stats.Sets)
+	assert.Equal(t, int64(2), stats.Gets)
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(1), stats.Evictions)
+	assert.Equal(t, int64(1), stats.Count)
+
+	assert.Zero(t, cache.WindowedStats(0).Sets)
+	assert.Zero(t, New(Config{Capacity: 1}).WindowedStats(time.Minute).Sets)
+}
+
+func TestStatsWindow(t *testing.T) {
+	w := newStatsWindow(3 * time.Second)
+	base := time.Unix(1000, 0)
+	at := func(seconds int64) time.Time { return base.Add(time.Duration(seconds) * time.Second) }
+
+	// Snapshots hold the number of operations counted before each second
+	for _, s := range []struct{ second, sets int64 }{{0, 0}, {1, 5}, {3, 8}, {4, 10}} {
+		snapshot := w.claim(at(s.second))
+		assert.NotNil(t, snapshot)
+		snapshot.stats.Sets = s.sets
+	}
+	assert.Nil(t, w.claim(at(4)))
+
+	current := Stats{Sets: 12}
+	assert.Equal(t, int64(10), w.since(at(4), time.Second, current).Sets)
+	assert.Equal(t, int64(8), w.since(at(4), 2*time.Second, current).Sets)
+	assert.Equal(t, int64(8), w.since(at(4), 3*time.Second, current).Sets)
+	assert.Equal(t, int64(8), w.since(at(4), time.Hour, current).Sets)
+	assert.Equal(t, int64(12), w.since(at(9), 3*time.Second, current).Sets)
+}